@@ -1,8 +1,9 @@
 package assembler
 
 import (
-	"errors"
+	"encoding/binary"
 	"fmt"
+	"sort"
 
 	// "strings"
 
@@ -12,6 +13,44 @@ import (
 type AssembleConfig struct {
 	Data uint32
 	Text uint32
+	// the base addresses of the other sections by name, like rodata and ktext,
+	// a section not given follows the previous one of its kind
+	Sections map[string]uint32
+	// directories to search for .include files, after the directory of the including file
+	IncludeDirs []string
+	// expand the branches and jumps out of range instead of reporting them
+	Relax bool
+	// make a relocatable object for linking, all the sections start at 0
+	Relocatable bool
+	// place the code and the data sections in separate memories starting at Text and Data,
+	// of CodeSize and DataSize bytes, or the size of the image if 0
+	Harvard  bool
+	CodeSize uint32
+	DataSize uint32
+	// the most significant byte of the words and the halves goes first, both in the code and the data
+	BigEndian bool
+	// start in the mode of .set noreorder, where the delay slots are written by hand
+	NoReorder bool
+	// the code runs with the MIPS I load delay, so the loads are never moved into the delay slots,
+	// where the branch target would see the old value
+	LoadDelay bool
+	// the release of MIPS32 whose instructions are accepted, instruction.MIPS32R1 if 0
+	ISARevision int
+}
+
+func (this AssembleConfig) revision() int {
+	if this.ISARevision == 0 {
+		return instruction.MIPS32R1
+	}
+	return this.ISARevision
+}
+
+// ByteOrder is the order of the bytes of the words and the halves in the image
+func (this AssembleConfig) ByteOrder() binary.ByteOrder {
+	if this.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
 }
 
 type Segment struct {
@@ -19,34 +58,70 @@ type Segment struct {
 	End   uint32
 }
 
+// SectionImage is the assembled content of one section
+type SectionImage struct {
+	Name   string
+	Flags  string // of SECTION_ALLOC, SECTION_WRITE and SECTION_EXEC
+	Start  uint32
+	Data   []uint8
+	Relocs []Relocation // only in relocatable objects
+}
+
 type AssembleResult struct {
-	Full Segment
-	Data Segment
-	Text Segment
-	Bin  []uint8
+	Full        Segment
+	Data        Segment
+	Text        Segment
+	Bin         []uint8
+	Diagnostics Diagnostics
+	// the assembled source lines and the symbol table, both in the order of addresses
+	Listing []ListingLine
+	Symbols []Symbol
+	// the content of each section without the padding of Bin, the code sections first,
+	// and the entry point for executables
+	Sections []SectionImage
+	Entry    uint32
+	// the images of the code and the data memories in Harvard mode, instead of Full and Bin
+	Memories []Memory
+	// the byte order of the images, and the release of MIPS32 of the instructions
+	BigEndian   bool
+	ISARevision int
+}
+
+// the entry point is _start or main if defined, otherwise the start of the text
+func findEntry(labels map[string]uint32, text uint32) uint32 {
+	for _, name := range []string{"_start", "main"} {
+		if addr, ok := labels[name]; ok {
+			return addr
+		}
+	}
+	return text
 }
 
-func assembleWithError(content []string, config AssembleConfig, size int32) (retinstrs []instruction.Instruction, asresult AssembleResult, err error) {
+func assembleWithError(content []SourceLine, config AssembleConfig, size int32) (retinstrs []instruction.Instruction, asresult AssembleResult, diags Diagnostics) {
 
 	defer func() {
 		if cr := recover(); cr != nil {
-			err = cr.(error)
-		} else {
-			err = nil
+			diags = append(diags, Diagnostic{Severity: SEVERITY_ERROR, Message: fmt.Sprintf("Internal error: %v", cr)})
 		}
 	}()
 
-	dataEnd := config.Data
-	textEnd := config.Text
+	diags = make(Diagnostics, 0)
+
+	if config.Relocatable {
+		config.Data, config.Text = 0, 0
+	}
 
 	realSize := uint32(0)
 	if size > 0 {
 		realSize = uint32(size)
 	}
 
-	segs := TrimSplitSegment(content)
+	content = ExpandIncludes(content, config.IncludeDirs, &diags)
+	content, constants := collectConstants(ExpandMacros(content, &diags), &diags)
+	content, link := collectLinkage(content, &diags)
+	sections, outside := splitSections(content, !config.NoReorder, &diags)
 
-	buildBits := size > 0
+	buildBits := size > 0 && !config.Relocatable && !config.Harvard
 
 	var result []uint8
 	if buildBits {
@@ -59,46 +134,170 @@ func assembleWithError(content []string, config AssembleConfig, size int32) (ret
 
 	symbolTable := make(map[string]uint32)
 
-	data, hasData := segs["data"]
-	if hasData {
-		dataSeg, dataSymbols := buildData(data, config, buildBits)
-		for k, v := range dataSymbols {
-			_, exists := symbolTable[k]
-			if exists {
-				panic(errors.New(fmt.Sprintf("Symbol %s has been defined.", k)))
-			}
+	names := make([]string, 0, len(sections))
+	flags := make(map[string]string)
+	byName := make(map[string]*section)
+	for _, sec := range sections {
+		names = append(names, sec.name)
+		flags[sec.name] = sec.flags
+		byName[sec.name] = sec
+	}
+	codeNames, dataNames := sortSections(names, flags)
+
+	// the data is laid out first, so the data labels are known while expanding the pseudo-instructions
+	follow := config.Data
+	for _, name := range dataNames {
+		sec := byName[name]
+		sec.start = sectionBase(config, name, follow)
+		buildData(sec, config, constants, symbolTable, &diags)
+		for k, v := range sec.labels {
 			symbolTable[k] = v
 		}
-		dataEnd = config.Data + uint32(len(dataSeg))
-		if buildBits {
-			for i, val := range dataSeg {
-				result[config.Data+uint32(i)] = val
+		follow = sec.end
+	}
+
+	var reloc *relocator
+	if config.Relocatable {
+		reloc = newRelocator(constants.lookup(symbolTable))
+		for _, name := range dataNames {
+			reloc.addLabels(byName[name].labels, name)
+		}
+	}
+	for _, name := range link.names {
+		line := link.externs[name]
+		if _, defined := symbolTable[name]; defined {
+			diags.errorf(line, name, "Symbol %s is declared .extern but defined here", name)
+		} else {
+			if !config.Relocatable {
+				diags.errorf(line, name, "External symbol %s can only be resolved by linking, assemble with -c", name)
+			} else {
+				reloc.sections[name] = ""
 			}
+			symbolTable[name] = 0 // no more errors for the uses
 		}
 	}
 
-	texts, hasText := segs["text"]
-	if hasText {
-		instrs := buildText(texts, config, symbolTable)
-		retinstrs = instrs
-		textEnd = config.Text + (uint32(len(instrs)) << 2)
-		if buildBits {
-			for i, bits := range instruction.ToBin(instrs) {
-				for j := uint32(0); j < 4; j++ {
-					result[config.Text+(uint32(i)<<2)+j] = uint8(bits >> (j << 3) & 0xff)
-				}
+	// a branch to a code section laid out later is only relaxed when all the sections are laid out,
+	// so they are laid out again from the same symbols until no branch is relaxed
+	base := mergeSymbols(symbolTable)
+	relax := make(map[string]map[relaxKey]int)
+	for _, name := range codeNames {
+		relax[name] = make(map[relaxKey]int)
+	}
+	for {
+		pass := make(Diagnostics, 0)
+		for k := range symbolTable {
+			delete(symbolTable, k)
+		}
+		for k, v := range base {
+			symbolTable[k] = v
+		}
+		follow = config.Text
+		for _, name := range codeNames {
+			sec := byName[name]
+			sec.start = sectionBase(config, name, follow)
+			layoutText(sec, config, symbolTable, constants, relax[name], &pass)
+			follow = sec.end
+		}
+		changed := false
+		for _, name := range codeNames {
+			sec := byName[name]
+			if config.Relax && relaxPass(sec.layout.syntaxs, sec.layout.keys, relax[name], sec.start, constants.lookup(symbolTable)) {
+				changed = true
 			}
 		}
+		if !changed {
+			diags = append(diags, pass...)
+			break
+		}
+	}
+	codeLabels := make(map[string]uint32)
+	for _, name := range codeNames {
+		sec := byName[name]
+		for k, v := range sec.labels {
+			codeLabels[k] = v
+		}
+		if reloc != nil {
+			reloc.addLabels(sec.labels, name)
+		}
+	}
+	for _, name := range codeNames {
+		encodeText(byName[name], symbolTable, constants, reloc, config, &diags)
 	}
 
-	if len(segs[DEFAULT_SEGMENT]) > 0 {
-		println("Warning: some instruction not in any special segment")
+	// data may refer to any symbol, so resolve it after the text is laid out
+	for _, name := range dataNames {
+		sec := byName[name]
+		resolveDataFixups(sec, constants.lookup(symbolTable), reloc, config.ByteOrder(), &diags)
+		fillListingData(sec.listing, sec.data, sec.start)
+	}
+	constants.check(symbolTable, &diags)
+
+	for name, line := range link.globals {
+		if _, defined := symbolTable[name]; !defined && constants.defs[name].expr == "" {
+			diags.errorf(line, name, "Global symbol %s is not defined", name)
+		}
+	}
+	symbols := buildSymbols(sections, constants, link, constants.lookup(symbolTable))
+
+	if len(outside) > 0 {
+		diags.warnf(outside[0], "", "Some instruction not in any special segment")
+	}
+
+	images := make([]SectionImage, 0, len(sections))
+	listing := make([]ListingLine, 0)
+	for _, name := range append(codeNames, dataNames...) {
+		sec := byName[name]
+		image := sec.image()
+		if reloc != nil {
+			image.Relocs = reloc.relocs[name]
+		}
+		images = append(images, image)
+		listing = append(listing, sec.listing...)
+	}
+	memories := layoutMemories(config, realSize)
+	if !config.Relocatable {
+		checkLayout(images, memories, func(sec SectionImage, format string, args ...interface{}) {
+			diags.errorf(byName[sec.Name].line, "", format, args...)
+		})
+	}
+	if config.Harvard {
+		realSize, result = 0, make([]uint8, 0)
+		if !diags.HasError() {
+			buildImages(images, memories)
+		}
+	} else if buildBits && !diags.HasError() {
+		buildImages(images, memories)
+		result = memories[0].Bin
+	}
+	sort.SliceStable(listing, func(i, j int) bool { return listing[i].Address < listing[j].Address })
+
+	dataSeg, textSeg := Segment{config.Data, config.Data}, Segment{config.Text, config.Text}
+	if sec, ok := byName["data"]; ok {
+		dataSeg.End = sec.end
+	}
+	if sec, ok := byName["text"]; ok {
+		textSeg.End = sec.end
+		retinstrs = sec.instrs
+	}
+	asresult = AssembleResult{Segment{0, realSize}, dataSeg, textSeg, result, diags, listing, symbols, images, findEntry(codeLabels, config.Text), nil, config.BigEndian, config.revision()}
+	if config.Harvard {
+		asresult.Memories = memories
+	}
+	return retinstrs, asresult, diags
+}
+
+// AssembleSource assembles the lines, the error returned is Diagnostics when any error occurs
+func AssembleSource(content []SourceLine, config AssembleConfig, size int32) ([]instruction.Instruction, AssembleResult, error) {
+	instrs, result, diags := assembleWithError(content, config, size)
+	diags = diags.sorted()
+	result.Diagnostics = diags
+	if diags.HasError() {
+		return instrs, result, diags
 	}
-	asresult = AssembleResult{Segment{0, realSize}, Segment{config.Data, dataEnd}, Segment{config.Text, textEnd}, result}
-	return retinstrs, asresult, err
+	return instrs, result, nil
 }
 
 func Assemble(content []string, config AssembleConfig, size int32) ([]instruction.Instruction, AssembleResult, error) {
-	instrs, result, rerr := assembleWithError(content, config, size)
-	return instrs, result, rerr
+	return AssembleSource(NewSource("", content), config, size)
 }
@@ -0,0 +1,120 @@
+package assembler
+
+import (
+	"strings"
+)
+
+type constant struct {
+	expr string
+	line SourceLine
+}
+
+// symbolic constants defined by .eqv/.set/.equ, evaluated when used
+type constTable struct {
+	defs  map[string]constant
+	names []string // in the order of definition
+	busy  map[string]bool
+}
+
+func newConstTable() *constTable {
+	return &constTable{make(map[string]constant), make([]string, 0), make(map[string]bool)}
+}
+
+// lookup of the labels first, then the constants
+func (this *constTable) lookup(labels map[string]uint32) SymbolLookup {
+	var lookup SymbolLookup
+	lookup = func(name string) (int64, error) {
+		if val, ok := labels[name]; ok {
+			return int64(val), nil
+		}
+		def, ok := this.defs[name]
+		if !ok {
+			return 0, undefinedError{name}
+		}
+		if this.busy[name] {
+			panic(errorAt(name, "Constant %s is defined recursively", name))
+		}
+		this.busy[name] = true
+		defer delete(this.busy, name)
+		return evalExpr(def.expr, lookup)
+	}
+	return lookup
+}
+
+// the options of .set which change nothing here, like the warnings for $at and the macros
+var ignoredSetOptions = map[string]bool{
+	"at": true, "noat": true, "macro": true, "nomacro": true, "move": true, "nomove": true,
+	"bopt": true, "nobopt": true, "volatile": true, "novolatile": true, "nomips16": true, "nomicromips": true,
+	"mips0": true, "mips1": true, "mips2": true, "mips32": true, "mips32r2": true,
+}
+
+// isIgnoredSetOption is whether the line is .set with one of ignoredSetOptions
+func isIgnoredSetOption(text string) bool {
+	directive, rem := getDirective(text)
+	return directive == "set" && ignoredSetOptions[strings.ToLower(strings.Trim(rem, " \t"))]
+}
+
+func isConstantDirective(name string) bool {
+	return name == "eqv" || name == "set" || name == "equ"
+}
+
+// parse "NAME, expr" or "NAME expr"
+func parseConstant(str string) (string, string) {
+	ind := strings.IndexAny(str, " \t,")
+	if ind == -1 {
+		panic(errorAt(str, "Constant without value: %s", str))
+	}
+	name, expr := str[:ind], strings.Trim(str[ind:], " \t")
+	expr = strings.Trim(strings.TrimPrefix(expr, ","), " \t")
+	if !symbolRegex.MatchString(name) {
+		panic(errorAt(name, "Invalid constant name: %s", name))
+	}
+	if expr == "" {
+		panic(errorAt(str, "Constant without value: %s", str))
+	}
+	evalExpr(expr, func(string) (int64, error) { return 1, nil }) // check the syntax
+	return name, expr
+}
+
+// collectConstants takes the constant definitions out of the lines
+func collectConstants(content []SourceLine, diags *Diagnostics) ([]SourceLine, *constTable) {
+	table := newConstTable()
+	result := make([]SourceLine, 0, len(content))
+	for _, raw := range content {
+		line := trimSourceLine(raw)
+		directive, rem := getDirective(line.Text)
+		if isIgnoredSetOption(line.Text) {
+			continue
+		}
+		if _, isMode := reorderMode(line.Text); !isConstantDirective(directive) || isMode {
+			result = append(result, raw)
+			continue
+		}
+		diags.guard(line, func() {
+			name, expr := parseConstant(rem)
+			if _, exists := table.defs[name]; exists {
+				panic(errorAt(name, "Symbol %s has been defined.", name))
+			}
+			table.defs[name] = constant{expr, line}
+			table.names = append(table.names, name)
+		})
+	}
+	return result, table
+}
+
+// check evaluates all constants with the final labels and reports errors at the definitions
+func (this *constTable) check(labels map[string]uint32, diags *Diagnostics) {
+	lookup := this.lookup(labels)
+	for _, name := range this.names {
+		def := this.defs[name]
+		if _, exists := labels[name]; exists {
+			diags.errorf(def.line, name, "Symbol %s has been defined.", name)
+			continue
+		}
+		diags.guard(def.line, func() {
+			if _, err := lookup(name); err != nil {
+				panic(err)
+			}
+		})
+	}
+}
@@ -1,20 +1,160 @@
 package assembler
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
-	"errors"
 )
 
 var dataTokenRegex *regexp.Regexp
 var groupNames []string
 
-func getDataTokens(str string) (string, string, []uint8) {
+var symbolRegex = regexp.MustCompile(`^[A-Za-z_][\w.]*$`)
+
+// alignment (in bytes) the data type is placed at
+var dataAlignments = map[string]uint32{
+	"word": 4,
+	"half": 2,
+}
+
+// size (in bytes) of one element of the data type
+var dataSizes = map[string]uint32{
+	"word": 4,
+	"half": 2,
+	"byte": 1,
+}
+
+func isDataDirective(name string) bool {
+	switch name {
+	case "word", "half", "byte", "ascii", "asciiz", "space", "align":
+		return true
+	}
+	return false
+}
+
+func alignUp(addr uint32, align uint32) uint32 {
+	if align <= 1 {
+		return addr
+	}
+	return (addr + align - 1) / align * align
+}
+
+func parseStringLiteral(str string) []uint8 {
+	if len(str) < 2 || !strings.HasPrefix(str, "\"") || !strings.HasSuffix(str, "\"") {
+		panic(errorAt(str, "Invalid string literal: %s", str))
+	}
+	raw := str[1 : len(str)-1]
+	data := make([]uint8, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		chr := raw[i]
+		if chr != '\\' {
+			data = append(data, chr)
+			continue
+		}
+		i++
+		if i >= len(raw) {
+			panic(errorAt(str, "Invalid escape at the end of string literal: %s", str))
+		}
+		switch raw[i] {
+		case 'n':
+			data = append(data, '\n')
+		case 'r':
+			data = append(data, '\r')
+		case 't':
+			data = append(data, '\t')
+		case '0':
+			data = append(data, 0)
+		case '\\', '"', '\'':
+			data = append(data, raw[i])
+		default:
+			panic(errorAt(str, "Unknown escape \\%c in string literal: %s", raw[i], str))
+		}
+	}
+	return data
+}
+
+func checkDataRange(str string, val int64, size uint32) uint32 {
+	bits := size << 3
+	if size < 8 && (val < -(int64(1)<<(bits-1)) || val >= int64(1)<<bits) {
+		panic(errorAt(str, "Value %s out of range for %d-byte data", str, size))
+	}
+	return uint32(val)
+}
+
+// a data item referring to symbols, patched after all segments are laid out
+type dataFixup struct {
+	addr uint32
+	size uint32
+	expr string
+	line SourceLine
+}
+
+type dataItem struct {
+	value uint32
+	expr  string // not empty if the value can't be evaluated now
+}
+
+func parseDataItem(str string, size uint32, lookup SymbolLookup) dataItem {
+	val, err := evalExpr(str, lookup)
+	if err != nil {
+		if !isUndefined(err) {
+			panic(err)
+		}
+		return dataItem{0, str}
+	}
+	return dataItem{checkDataRange(str, val, size), ""}
+}
+
+// evaluate the expression which must be known now, like sizes and counts
+func evalKnownExpr(str string, lookup SymbolLookup) int64 {
+	val, err := evalExpr(str, lookup)
+	if err != nil {
+		panic(errorAt(str, "%s must be known here: %s", str, err.Error()))
+	}
+	return val
+}
+
+// parse "value" or "value:count" list items
+func parseDataList(content string, size uint32, lookup SymbolLookup) []dataItem {
+	result := make([]dataItem, 0)
+	for _, item := range splitArgs(content) {
+		count := int64(1)
+		if ind := strings.LastIndex(item, ":"); ind != -1 && !strings.HasSuffix(item, "'") {
+			count = evalKnownExpr(strings.Trim(item[ind+1:], " \t"), lookup)
+			if count < 0 {
+				panic(errorAt(item, "Invalid repeat count: %s", item))
+			}
+			item = strings.Trim(item[:ind], " \t")
+		}
+		if item == "" {
+			panic(errorAt(content, "Empty data item: %s", content))
+		}
+		val := parseDataItem(item, size, lookup)
+		for i := int64(0); i < count; i++ {
+			result = append(result, val)
+		}
+	}
+	return result
+}
+
+func putData(data []uint8, val uint32, size uint32, order binary.ByteOrder) {
+	switch size {
+	case 1:
+		data[0] = uint8(val)
+	case 2:
+		order.PutUint16(data, uint16(val))
+	case 4:
+		order.PutUint32(data, val)
+	}
+}
+
+func getDataTokens(str string, lookup SymbolLookup, order binary.ByteOrder) (string, []uint8, uint32, []dataFixup) {
 	match := dataTokenRegex.FindStringSubmatch(str)
 	result := make(map[string]string)
-	if len(match)<len(groupNames) {
-		panic(errors.New("Data token parsing failed"))
+	if len(match) < len(groupNames) {
+		panic(errors.New(fmt.Sprintf("Data token parsing failed: %s", str)))
 	}
 	for i, name := range groupNames {
 		if i != 0 && name != "" { // 第一个分组为空（也就是整个匹配）
@@ -22,40 +162,118 @@ func getDataTokens(str string) (string, string, []uint8) {
 		}
 	}
 	data := make([]uint8, 0)
+	fixups := make([]dataFixup, 0)
+	align := dataAlignments[result["type"]]
+	content := result["content"]
 	switch result["type"] {
-	case "asciiz":
-		raw := strings.Trim(result["content"], "\"")
-		raw = strings.Replace(raw, "\\n", "\n", -1) // TODO
-		raw = strings.Replace(raw, "\\r", "\r", -1)
-		for _, chr := range raw {
-			data = append(data, uint8(chr))
+	case "ascii", "asciiz":
+		data = append(data, parseStringLiteral(content)...)
+		if result["type"] == "asciiz" {
+			data = append(data, uint8(0))
+		}
+	case "word", "half", "byte":
+		size := dataSizes[result["type"]]
+		for _, item := range parseDataList(content, size, lookup) {
+			if item.expr != "" { // addr is relative to the item here
+				fixups = append(fixups, dataFixup{uint32(len(data)), size, item.expr, SourceLine{}})
+			}
+			data = append(data, make([]uint8, size)...)
+			putData(data[len(data)-int(size):], item.value, size, order)
+		}
+	case "space":
+		n := evalKnownExpr(content, lookup)
+		if n < 0 || n > 0xffffffff {
+			panic(errorAt(content, "Invalid space size: %s", content))
+		}
+		data = make([]uint8, n)
+	case "align":
+		n := evalKnownExpr(content, lookup)
+		if n < 0 || n > 16 {
+			panic(errorAt(content, "Invalid alignment: %s", content))
 		}
-		data = append(data, uint8(0))
+		align = uint32(1) << n
+	default:
+		panic(errorAt("."+result["type"], "No this data type: %s", result["type"]))
 	}
-	return result["symbol"], result["type"], data
+	return result["type"], data, align, fixups
 }
 
-func buildData(content []string, config AssembleConfig, buildBits bool) ([]uint8, map[string]uint32) {
-	dataTokenRegex = regexp.MustCompile(`^(?P<symbol>[\w]+)[\s]*:[\s]*\.(?P<type>[\w]+)[\s]*(?P<content>\S[\s\S]*)$`)
+// buildData lays out the data section, defined is the symbols defined out of the section
+func buildData(sec *section, config AssembleConfig, constants *constTable, defined map[string]uint32, diags *Diagnostics) {
+	dataTokenRegex = regexp.MustCompile(`^\.(?P<type>[\w]+)[\s]*(?P<content>[\s\S]*)$`)
 	groupNames = dataTokenRegex.SubexpNames()
+	bss := sec.name == "bss"
 	result := make([]uint8, 0)
 	symbolTable := make(map[string]uint32)
-	dataOffset := config.Data
-	for _, str := range content {
-		symbol, _, data := getDataTokens(str)
-		_, exists := symbolTable[symbol]
-		if exists {
-			panic(errors.New(fmt.Sprintf("Symbol %s has been defined.", symbol)))
+	fixups := make([]dataFixup, 0)
+	listing := make([]ListingLine, 0, len(sec.lines))
+	dataOffset := sec.start
+	known := mergeSymbols(defined) // and the labels so far
+	lookup := constants.lookup(known)
+	if config.Relocatable { // the labels are relocated, so keep all references as fixups
+		lookup = constants.lookup(map[string]uint32{})
+	}
+	pending := make([]string, 0) // labels bound to the next allocation
+	for _, line := range sec.lines {
+		labels, str := splitLabels(line.Text)
+		for _, symbol := range labels {
+			_, exists := symbolTable[symbol]
+			_, existsOut := defined[symbol]
+			for _, name := range pending {
+				exists = exists || name == symbol
+			}
+			if exists || existsOut {
+				diags.errorf(line, symbol, "Symbol %s has been defined.", symbol)
+				continue
+			}
+			pending = append(pending, symbol)
 		}
-		symbolTable[symbol] = dataOffset
-		if buildBits {
-			for _, val := range data {
-				result = append(result, val)
-				dataOffset++
+		if str == "" {
+			continue
+		}
+		diags.guard(line, func() {
+			dataType, data, align, itemFixups := getDataTokens(str, lookup, config.ByteOrder())
+			if bss && dataType != "space" && dataType != "align" {
+				panic(errorAt(str, "Only .space and .align can be in .bss: %s", str))
+			}
+			for aligned := alignUp(dataOffset, align); dataOffset < aligned; dataOffset++ {
+				result = append(result, 0)
+			}
+			for _, symbol := range pending {
+				symbolTable[symbol] = dataOffset
+				known[symbol] = dataOffset
+			}
+			pending = pending[:0]
+			for _, fix := range itemFixups {
+				fix.addr += dataOffset
+				fix.line = line
+				fixups = append(fixups, fix)
 			}
-		} else {
+			listing = append(listing, ListingLine{Source: line, Address: dataOffset, Data: make([]uint8, len(data))})
+			result = append(result, data...)
 			dataOffset += uint32(len(data))
-		}
+		})
+	}
+	for _, symbol := range pending {
+		symbolTable[symbol] = dataOffset
+	}
+	sec.data, sec.labels, sec.fixups, sec.listing = result, symbolTable, fixups, listing
+	sec.end = dataOffset
+}
+
+// patch the symbol references in the data of the section
+func resolveDataFixups(sec *section, lookup SymbolLookup, reloc *relocator, order binary.ByteOrder, diags *Diagnostics) {
+	data, base := sec.data, sec.start
+	for _, fix := range sec.fixups {
+		diags.guard(fix.line, func() {
+			if reloc != nil {
+				reloc.relocateData(fix, sec.name)
+			}
+			val, err := evalExpr(fix.expr, lookup)
+			if err != nil {
+				panic(err)
+			}
+			putData(data[fix.addr-base:], checkDataRange(fix.expr, val, fix.size), fix.size, order)
+		})
 	}
-	return result, symbolTable
 }
@@ -0,0 +1,172 @@
+package assembler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Severity uint8
+
+const (
+	SEVERITY_ERROR = Severity(iota)
+	SEVERITY_WARNING
+)
+
+func (this Severity) String() string {
+	switch this {
+	case SEVERITY_ERROR:
+		return "error"
+	case SEVERITY_WARNING:
+		return "warning"
+	}
+	return "unknown"
+}
+
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (this Position) String() string {
+	file := this.File
+	if file == "" {
+		file = "<input>"
+	}
+	if this.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d", file, this.Line, this.Column)
+	}
+	if this.Line == 0 { // for a whole file, like an object to link
+		return file
+	}
+	return fmt.Sprintf("%s:%d", file, this.Line)
+}
+
+type Diagnostic struct {
+	Position
+	Severity Severity
+	Message  string
+	// the macro invocations the position is expanded from, innermost first
+	Invocations []Position
+}
+
+func (this Diagnostic) String() string {
+	result := fmt.Sprintf("%s: %s: %s", this.Position, this.Severity, this.Message)
+	for _, pos := range this.Invocations {
+		result += fmt.Sprintf("\n    expanded from macro invoked at %s", pos)
+	}
+	return result
+}
+
+// Diagnostics is the list of messages of one run, it is also used as the error of Assemble
+type Diagnostics []Diagnostic
+
+func (this Diagnostics) Error() string {
+	strs := make([]string, 0, len(this))
+	for _, diag := range this {
+		strs = append(strs, diag.String())
+	}
+	return strings.Join(strs, "\n")
+}
+
+func (this Diagnostics) HasError() bool {
+	for _, diag := range this {
+		if diag.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+func (this Diagnostics) Errors() Diagnostics {
+	result := make(Diagnostics, 0)
+	for _, diag := range this {
+		if diag.Severity == SEVERITY_ERROR {
+			result = append(result, diag)
+		}
+	}
+	return result
+}
+
+// sorted gives the diagnostics in the order of the positions, the same message at the same
+// position is only kept once, like the one of the instructions expanded from one line
+func (this Diagnostics) sorted() Diagnostics {
+	type key struct {
+		pos     Position
+		message string
+	}
+	seen := make(map[key]bool)
+	result := make(Diagnostics, 0, len(this))
+	for _, diag := range this {
+		if k := (key{diag.Position, diag.Message}); !seen[k] {
+			seen[k] = true
+			result = append(result, diag)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i].Position, result[j].Position
+		if a.File != b.File {
+			return a.File < b.File
+		} else if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return result
+}
+
+// an error located at the fragment of the source line
+type lineError struct {
+	fragment string
+	message  string
+}
+
+func (this lineError) Error() string {
+	return this.message
+}
+
+func errorAt(fragment string, format string, args ...interface{}) error {
+	return lineError{fragment, fmt.Sprintf(format, args...)}
+}
+
+func (this *Diagnostics) report(severity Severity, line SourceLine, fragment string, message string) {
+	pos := Position{line.File, line.Line, line.Column}
+	if ind := strings.Index(line.Text, fragment); fragment != "" && ind != -1 {
+		pos.Column += ind
+	}
+	invocations := make([]Position, 0)
+	for inv := line.Invocation; inv != nil; inv = inv.Invocation {
+		invocations = append(invocations, Position{inv.File, inv.Line, inv.Column})
+	}
+	*this = append(*this, Diagnostic{pos, severity, message, invocations})
+}
+
+func (this *Diagnostics) errorf(line SourceLine, fragment string, format string, args ...interface{}) {
+	this.report(SEVERITY_ERROR, line, fragment, fmt.Sprintf(format, args...))
+}
+
+func (this *Diagnostics) warnf(line SourceLine, fragment string, format string, args ...interface{}) {
+	this.report(SEVERITY_WARNING, line, fragment, fmt.Sprintf(format, args...))
+}
+
+// run f for the line, and turn the panic in it into a diagnostic
+func (this *Diagnostics) guard(line SourceLine, f func()) (ok bool) {
+	defer func() {
+		if cr := recover(); cr != nil {
+			ok = false
+			switch err := cr.(type) {
+			case lineError:
+				this.errorf(line, err.fragment, "%s", err.message)
+			case undefinedError:
+				this.errorf(line, err.name, "%s", err.Error())
+			case error:
+				this.errorf(line, "", "%s", err.Error())
+			default:
+				this.errorf(line, "", "%v", cr)
+			}
+		}
+	}()
+	f()
+	return true
+}
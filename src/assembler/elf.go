@@ -0,0 +1,485 @@
+package assembler
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"../instruction"
+)
+
+const (
+	// MIPS32 and the o32 ABI, as gcc sets for -march=mips32
+	ELF_MIPS_FLAGS = 0x50001000
+	ELF_ALIGN      = 4
+	// the architecture bits of the flags, and the one of -march=mips32r2
+	ELF_MIPS_ARCH      = 0xf0000000
+	ELF_MIPS_ARCH_32R2 = 0x70000000
+)
+
+// the flags of the ELF files of the release
+func elfFlags(revision int) uint32 {
+	if revision >= instruction.MIPS32R2 {
+		return ELF_MIPS_FLAGS&^ELF_MIPS_ARCH | ELF_MIPS_ARCH_32R2
+	}
+	return ELF_MIPS_FLAGS
+}
+
+func elfRevision(flags uint32) int {
+	if flags&ELF_MIPS_ARCH == ELF_MIPS_ARCH_32R2 {
+		return instruction.MIPS32R2
+	}
+	return instruction.MIPS32R1
+}
+
+// an ELF section in the file, with the content and the header to be completed
+type elfSection struct {
+	name   string
+	header elf.Section32
+	data   []uint8
+}
+
+type elfWriter struct {
+	order    binary.ByteOrder
+	flags    uint32
+	sections []*elfSection
+	shstrtab []uint8
+}
+
+func newELFWriter(order binary.ByteOrder, flags uint32) *elfWriter {
+	null := &elfSection{}
+	return &elfWriter{order, flags, []*elfSection{null}, []uint8{0}}
+}
+
+func (this *elfWriter) addSection(name string, header elf.Section32, data []uint8) int {
+	header.Name = uint32(len(this.shstrtab))
+	if header.Type != uint32(elf.SHT_NOBITS) {
+		header.Size = uint32(len(data))
+	}
+	this.shstrtab = append(append(this.shstrtab, name...), 0)
+	this.sections = append(this.sections, &elfSection{name, header, data})
+	return len(this.sections) - 1
+}
+
+// the symbol table and its string table, locals must go first as ELF requires
+func (this *elfWriter) addSymbols(symbols []elf.Sym32, names []string, locals int) {
+	strtab := []uint8{0}
+	symtab := new(bytes.Buffer)
+	binary.Write(symtab, this.order, elf.Sym32{})
+	for i, sym := range symbols {
+		sym.Name = uint32(len(strtab))
+		strtab = append(append(strtab, names[i]...), 0)
+		binary.Write(symtab, this.order, sym)
+	}
+	strIndex := this.addSection(".strtab", elf.Section32{Type: uint32(elf.SHT_STRTAB), Addralign: 1}, strtab)
+	this.addSection(".symtab", elf.Section32{
+		Type:      uint32(elf.SHT_SYMTAB),
+		Link:      uint32(strIndex),
+		Info:      uint32(locals + 1),
+		Addralign: 4,
+		Entsize:   16,
+	}, symtab.Bytes())
+}
+
+// write the file, the sections with an address are loaded by the program headers if exec
+func (this *elfWriter) write(fileType elf.Type, entry uint32, exec bool) []uint8 {
+	shstrIndex := this.addSection(".shstrtab", elf.Section32{Type: uint32(elf.SHT_STRTAB), Addralign: 1}, nil)
+	this.sections[shstrIndex].data = this.shstrtab
+	this.sections[shstrIndex].header.Size = uint32(len(this.shstrtab))
+
+	loads := make([]*elfSection, 0)
+	if exec {
+		for _, sec := range this.sections {
+			if sec.header.Flags&uint32(elf.SHF_ALLOC) != 0 && sec.header.Size > 0 {
+				loads = append(loads, sec)
+			}
+		}
+	}
+
+	// the layout: header, program headers, section contents, section headers
+	offset := uint32(52 + 32*len(loads))
+	for _, sec := range this.sections[1:] {
+		// keep the file offset congruent to the address for loading
+		for offset%ELF_ALIGN != sec.header.Addr%ELF_ALIGN {
+			offset++
+		}
+		sec.header.Off = offset
+		if sec.header.Type != uint32(elf.SHT_NOBITS) {
+			offset += sec.header.Size
+		}
+	}
+	shoff := alignUp(offset, 4)
+
+	header := elf.Header32{
+		Type:      uint16(fileType),
+		Machine:   uint16(elf.EM_MIPS),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     entry,
+		Shoff:     shoff,
+		Flags:     this.flags,
+		Ehsize:    52,
+		Phentsize: 32,
+		Phnum:     uint16(len(loads)),
+		Shentsize: 40,
+		Shnum:     uint16(len(this.sections)),
+		Shstrndx:  uint16(shstrIndex),
+	}
+	if len(loads) > 0 {
+		header.Phoff = 52
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = uint8(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = uint8(elf.ELFDATA2LSB)
+	if this.order == binary.BigEndian {
+		header.Ident[elf.EI_DATA] = uint8(elf.ELFDATA2MSB)
+	}
+	header.Ident[elf.EI_VERSION] = uint8(elf.EV_CURRENT)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, this.order, header)
+	for _, sec := range loads {
+		flags := elf.PF_R
+		if sec.header.Flags&uint32(elf.SHF_WRITE) != 0 {
+			flags |= elf.PF_W
+		}
+		if sec.header.Flags&uint32(elf.SHF_EXECINSTR) != 0 {
+			flags |= elf.PF_X
+		}
+		filesz := sec.header.Size
+		if sec.header.Type == uint32(elf.SHT_NOBITS) {
+			filesz = 0
+		}
+		binary.Write(buf, this.order, elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    sec.header.Off,
+			Vaddr:  sec.header.Addr,
+			Paddr:  sec.header.Addr,
+			Filesz: filesz,
+			Memsz:  sec.header.Size,
+			Flags:  uint32(flags),
+			Align:  ELF_ALIGN,
+		})
+	}
+	for _, sec := range this.sections[1:] {
+		for uint32(buf.Len()) < sec.header.Off {
+			buf.WriteByte(0)
+		}
+		if sec.header.Type != uint32(elf.SHT_NOBITS) {
+			buf.Write(sec.data)
+		}
+	}
+	for uint32(buf.Len()) < shoff {
+		buf.WriteByte(0)
+	}
+	for _, sec := range this.sections {
+		binary.Write(buf, this.order, sec.header)
+	}
+	return buf.Bytes()
+}
+
+func sectionFlags(flags string) uint32 {
+	result := uint32(0)
+	if strings.Contains(flags, SECTION_ALLOC) {
+		result |= uint32(elf.SHF_ALLOC)
+	}
+	if strings.Contains(flags, SECTION_WRITE) {
+		result |= uint32(elf.SHF_WRITE)
+	}
+	if strings.Contains(flags, SECTION_EXEC) {
+		result |= uint32(elf.SHF_EXECINSTR)
+	}
+	return result
+}
+
+// the flags of an ELF section as the ones of .section
+func elfSectionFlags(flags elf.SectionFlag) string {
+	result := ""
+	if flags&elf.SHF_ALLOC != 0 {
+		result += SECTION_ALLOC
+	}
+	if flags&elf.SHF_WRITE != 0 {
+		result += SECTION_WRITE
+	}
+	if flags&elf.SHF_EXECINSTR != 0 {
+		result += SECTION_EXEC
+	}
+	return result
+}
+
+// the ELF symbols with the locals first, sections maps the names of the sections to their indexes
+func elfSymbols(symbols []Symbol, images []SectionImage, sections map[string]int) ([]elf.Sym32, []string, int) {
+	code := make(map[string]bool)
+	for _, sec := range images {
+		code[sec.Name] = isCodeSection(sec.Flags)
+	}
+	result := make([]elf.Sym32, 0, len(symbols))
+	names := make([]string, 0, len(symbols))
+	locals := 0
+	for _, global := range []bool{false, true} {
+		for _, symbol := range symbols {
+			if symbol.Global != global {
+				continue
+			}
+			bind := elf.STB_LOCAL
+			if global {
+				bind = elf.STB_GLOBAL
+			}
+			sym := elf.Sym32{Value: symbol.Address, Size: symbol.Size, Shndx: uint16(elf.SHN_ABS)}
+			if index, ok := sections[symbol.Segment]; ok {
+				sym.Shndx = uint16(index)
+			}
+			switch {
+			case symbol.Kind == SYMBOL_EXTERN:
+				sym.Info = elf.ST_INFO(bind, elf.STT_NOTYPE)
+				sym.Shndx = uint16(elf.SHN_UNDEF)
+			case symbol.Kind == SYMBOL_CONSTANT:
+				sym.Info = elf.ST_INFO(bind, elf.STT_NOTYPE)
+			case code[symbol.Segment]:
+				sym.Info = elf.ST_INFO(bind, elf.STT_FUNC)
+			default:
+				sym.Info = elf.ST_INFO(bind, elf.STT_OBJECT)
+			}
+			result = append(result, sym)
+			names = append(names, symbol.Name)
+		}
+		if !global {
+			locals = len(result)
+		}
+	}
+	return result, names, locals
+}
+
+func (this *elfWriter) addImages(images []SectionImage) map[string]int {
+	indexes := make(map[string]int)
+	for _, sec := range images {
+		header := elf.Section32{
+			Type:      uint32(elf.SHT_PROGBITS),
+			Flags:     sectionFlags(sec.Flags),
+			Addr:      sec.Start,
+			Addralign: ELF_ALIGN,
+		}
+		if sec.Name == "bss" {
+			header.Type, header.Size = uint32(elf.SHT_NOBITS), uint32(len(sec.Data))
+		}
+		indexes[sec.Name] = this.addSection("."+sec.Name, header, sec.Data)
+	}
+	return indexes
+}
+
+// ToELF makes an ELF32 executable of the sections, with the labels and constants in .symtab
+func (this AssembleResult) ToELF() []uint8 {
+	writer := newELFWriter(AssembleConfig{BigEndian: this.BigEndian}.ByteOrder(), elfFlags(this.ISARevision))
+	symbols, names, locals := elfSymbols(this.Symbols, this.Sections, writer.addImages(this.Sections))
+	writer.addSymbols(symbols, names, locals)
+	return writer.write(elf.ET_EXEC, this.Entry, true)
+}
+
+// ToELF makes an ELF32 relocatable object, the relocations are in .rela sections
+// against the section symbols for the local targets and the named symbols for the externs
+func (this Object) ToELF() []uint8 {
+	writer := newELFWriter(AssembleConfig{BigEndian: this.BigEndian}.ByteOrder(), elfFlags(this.ISARevision))
+	indexes := writer.addImages(this.Sections)
+
+	// the section symbols go first
+	sectionSymbols := make([]elf.Sym32, 0, len(this.Sections))
+	sectionNames := make([]string, 0, len(this.Sections))
+	for _, sec := range this.Sections {
+		sectionSymbols = append(sectionSymbols, elf.Sym32{Info: elf.ST_INFO(elf.STB_LOCAL, elf.STT_SECTION), Shndx: uint16(indexes[sec.Name])})
+		sectionNames = append(sectionNames, "")
+	}
+	symbols, names, locals := elfSymbols(this.Symbols, this.Sections, indexes)
+	symbols = append(sectionSymbols, symbols...)
+	names = append(sectionNames, names...)
+	locals += len(sectionSymbols)
+	symtabIndex := len(writer.sections) + 1 // after .strtab
+	writer.addSymbols(symbols, names, locals)
+
+	symbolIndexes := make(map[string]int)
+	for i, name := range names {
+		if i >= locals {
+			symbolIndexes[name] = i + 1
+		}
+	}
+	for i, sec := range this.Sections {
+		if len(sec.Relocs) == 0 {
+			continue
+		}
+		buf := new(bytes.Buffer)
+		for _, reloc := range sec.Relocs {
+			symbol := symbolIndexes[reloc.Symbol]
+			if reloc.Symbol == "" {
+				for j, target := range this.Sections {
+					if target.Name == reloc.Section {
+						symbol = j + 1
+					}
+				}
+			}
+			binary.Write(buf, writer.order, elf.Rela32{
+				Off:    reloc.Offset,
+				Info:   elf.R_INFO32(uint32(symbol), uint32(reloc.Type)),
+				Addend: reloc.Addend,
+			})
+		}
+		writer.addSection(".rela."+sec.Name, elf.Section32{
+			Type:      uint32(elf.SHT_RELA),
+			Flags:     uint32(elf.SHF_INFO_LINK),
+			Link:      uint32(symtabIndex),
+			Info:      uint32(indexes[this.Sections[i].Name]),
+			Addralign: 4,
+			Entsize:   12,
+		}, buf.Bytes())
+	}
+	return writer.write(elf.ET_REL, 0, false)
+}
+
+// ReadObject reads a relocatable object written by Object.ToELF or another assembler,
+// only the allocated sections are loaded
+func ReadObject(name string, content []uint8) (Object, error) {
+	result := Object{Name: name}
+	file, err := elf.NewFile(bytes.NewReader(content))
+	if err != nil {
+		return result, err
+	}
+	if file.Class != elf.ELFCLASS32 || file.Machine != elf.EM_MIPS || file.Type != elf.ET_REL {
+		return result, fmt.Errorf("not an ELF32 MIPS relocatable object")
+	}
+	order := file.ByteOrder
+	result.BigEndian = file.Data == elf.ELFDATA2MSB
+	if len(content) >= 40 { // e_flags, which debug/elf doesn't keep
+		result.ISARevision = elfRevision(order.Uint32(content[36:40]))
+	}
+
+	sectionNames := make(map[int]string)
+	for i, sec := range file.Sections {
+		if sec.Flags&elf.SHF_ALLOC == 0 || (sec.Type != elf.SHT_PROGBITS && sec.Type != elf.SHT_NOBITS) {
+			continue
+		}
+		data := make([]uint8, sec.Size)
+		if sec.Type != elf.SHT_NOBITS {
+			if data, err = sec.Data(); err != nil {
+				return result, err
+			}
+		}
+		name := strings.TrimPrefix(sec.Name, ".")
+		sectionNames[i] = name
+		result.Sections = append(result.Sections, SectionImage{name, elfSectionFlags(sec.Flags), 0, data, nil})
+	}
+
+	symbols, err := file.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return result, err
+	}
+	// the targets of the relocations by the index in .symtab
+	type target struct {
+		symbol  string
+		section string
+	}
+	targets := make([]target, len(symbols)+1)
+	for i, sym := range symbols {
+		section := sectionNames[int(sym.Section)]
+		if elf.ST_TYPE(sym.Info) == elf.STT_SECTION {
+			targets[i+1] = target{"", section}
+			continue
+		}
+		targets[i+1] = target{sym.Name, ""}
+		symbol := Symbol{sym.Name, uint32(sym.Value), section, uint32(sym.Size), SYMBOL_LABEL, elf.ST_BIND(sym.Info) == elf.STB_GLOBAL}
+		switch {
+		case sym.Section == elf.SHN_UNDEF:
+			symbol.Kind, symbol.Segment = SYMBOL_EXTERN, ""
+		case sym.Section == elf.SHN_ABS:
+			symbol.Kind, symbol.Segment = SYMBOL_CONSTANT, "abs"
+		case section == "" || sym.Name == "" || elf.ST_TYPE(sym.Info) == elf.STT_FILE:
+			continue // in a section not loaded
+		}
+		result.Symbols = append(result.Symbols, symbol)
+	}
+
+	for _, sec := range file.Sections {
+		if sec.Type != elf.SHT_RELA && sec.Type != elf.SHT_REL {
+			continue
+		}
+		section, ok := sectionNames[int(sec.Info)]
+		if !ok {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return result, err
+		}
+		relocs := make([]Relocation, 0)
+		size := 12
+		if sec.Type == elf.SHT_REL {
+			size = 8
+		}
+		for off := 0; off+size <= len(data); off += size {
+			info := order.Uint32(data[off+4:])
+			sym, kind := int(elf.R_SYM32(info)), elf.R_MIPS(elf.R_TYPE32(info))
+			if sym >= len(targets) {
+				return result, fmt.Errorf("relocation at 0x%x refers to symbol %d out of .symtab", order.Uint32(data[off:]), sym)
+			}
+			reloc := Relocation{order.Uint32(data[off:]), kind, targets[sym].symbol, targets[sym].section, 0}
+			if sec.Type == elf.SHT_RELA {
+				reloc.Addend = int32(order.Uint32(data[off+8:]))
+			} else {
+				reloc.Addend = implicitAddend(result.sectionData(section), reloc, order)
+			}
+			relocs = append(relocs, reloc)
+		}
+		if sec.Type == elf.SHT_REL {
+			pairHI16(result.sectionData(section), relocs, order)
+		}
+		for i := range result.Sections {
+			if result.Sections[i].Name == section {
+				result.Sections[i].Relocs = append(result.Sections[i].Relocs, relocs...)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (this Object) sectionData(name string) []uint8 {
+	for _, sec := range this.Sections {
+		if sec.Name == name {
+			return sec.Data
+		}
+	}
+	return nil
+}
+
+// the addend of a HI16 of REL relocations is completed by the %lo in the following LO16
+func pairHI16(data []uint8, relocs []Relocation, order binary.ByteOrder) {
+	for i, reloc := range relocs {
+		if reloc.Type != elf.R_MIPS_HI16 {
+			continue
+		}
+		for _, lo := range relocs[i+1:] {
+			if lo.Type == elf.R_MIPS_LO16 && lo.Symbol == reloc.Symbol && lo.Section == reloc.Section {
+				relocs[i].Addend += implicitAddend(data, lo, order)
+				break
+			}
+		}
+	}
+}
+
+// the addend kept in the field by REL relocations, HI16 only gives the high half
+func implicitAddend(data []uint8, reloc Relocation, order binary.ByteOrder) int32 {
+	if int(reloc.Offset)+4 > len(data) {
+		return 0
+	}
+	word := order.Uint32(data[reloc.Offset:])
+	switch reloc.Type {
+	case elf.R_MIPS_32:
+		return int32(word)
+	case elf.R_MIPS_26:
+		return int32((word & 0x3ffffff) << 2)
+	case elf.R_MIPS_HI16:
+		return int32(word << 16)
+	case elf.R_MIPS_LO16:
+		return int32(int16(word))
+	case elf.R_MIPS_PC16:
+		return int32(int16(word)) << 2
+	}
+	return 0
+}
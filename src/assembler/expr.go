@@ -0,0 +1,246 @@
+package assembler
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SymbolLookup gives the value of a symbol used in expressions
+type SymbolLookup func(name string) (int64, error)
+
+// the error of a symbol which is not defined (yet)
+type undefinedError struct {
+	name string
+}
+
+func (this undefinedError) Error() string {
+	return "No this symbol: " + this.name
+}
+
+func isUndefined(err error) bool {
+	_, ok := err.(undefinedError)
+	return ok
+}
+
+const (
+	ET_END = uint8(iota)
+	ET_NUM
+	ET_SYMBOL
+	ET_OP
+)
+
+type exprToken struct {
+	class uint8
+	value int64
+	text  string
+}
+
+func isSymbolStart(chr byte) bool {
+	return chr == '_' || chr == '.' || (chr >= 'a' && chr <= 'z') || (chr >= 'A' && chr <= 'Z')
+}
+
+func isSymbolChar(chr byte) bool {
+	return isSymbolStart(chr) || (chr >= '0' && chr <= '9')
+}
+
+// parse the char literal at the start of str, returns the value and the length
+func parseCharLiteral(str string) (int64, int) {
+	if len(str) >= 4 && str[1] == '\\' && str[3] == '\'' {
+		val := parseStringLiteral("\"" + str[1:3] + "\"")
+		return int64(val[0]), 4
+	}
+	if len(str) >= 3 && str[2] == '\'' && str[1] != '\\' {
+		return int64(str[1]), 3
+	}
+	panic(errorAt(str, "Invalid char literal: %s", str))
+}
+
+func tokenizeExpr(str string) []exprToken {
+	result := make([]exprToken, 0)
+	for i := 0; i < len(str); {
+		chr := str[i]
+		switch {
+		case chr == ' ' || chr == '\t':
+			i++
+		case chr >= '0' && chr <= '9':
+			end := i
+			for end < len(str) && isSymbolChar(str[end]) {
+				end++
+			}
+			val, err := strconv.ParseUint(str[i:end], 0, 64)
+			if err != nil {
+				panic(errorAt(str[i:end], "Invalid number: %s", str[i:end]))
+			}
+			result = append(result, exprToken{ET_NUM, int64(val), str[i:end]})
+			i = end
+		case chr == '\'':
+			val, n := parseCharLiteral(str[i:])
+			result = append(result, exprToken{ET_NUM, val, str[i : i+n]})
+			i += n
+		case isSymbolStart(chr):
+			end := i
+			for end < len(str) && isSymbolChar(str[end]) {
+				end++
+			}
+			result = append(result, exprToken{ET_SYMBOL, 0, str[i:end]})
+			i = end
+		case strings.HasPrefix(str[i:], "%hi(") || strings.HasPrefix(str[i:], "%lo("):
+			result = append(result, exprToken{ET_OP, 0, str[i : i+3]})
+			i += 3
+		case strings.HasPrefix(str[i:], "<<") || strings.HasPrefix(str[i:], ">>"):
+			result = append(result, exprToken{ET_OP, 0, str[i : i+2]})
+			i += 2
+		case strings.IndexByte("+-*/%&|^~()", chr) != -1:
+			result = append(result, exprToken{ET_OP, 0, str[i : i+1]})
+			i++
+		default:
+			panic(errorAt(str[i:i+1], "Unexpected character in expression: %c", chr))
+		}
+	}
+	return append(result, exprToken{ET_END, 0, ""})
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	lookup SymbolLookup
+	err    error // the first symbol error, the evaluation goes on to check the syntax
+}
+
+// binary operators from the lowest precedence
+var exprLevels = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (this *exprParser) peek() exprToken {
+	return this.tokens[this.pos]
+}
+
+func (this *exprParser) next() exprToken {
+	token := this.tokens[this.pos]
+	if token.class != ET_END {
+		this.pos++
+	}
+	return token
+}
+
+func (this *exprParser) fail(err error) {
+	if this.err == nil {
+		this.err = err
+	}
+}
+
+func (this *exprParser) parseBinary(level int) int64 {
+	if level == len(exprLevels) {
+		return this.parseUnary()
+	}
+	left := this.parseBinary(level + 1)
+	for {
+		token := this.peek()
+		matched := false
+		for _, op := range exprLevels[level] {
+			if token.class == ET_OP && token.text == op {
+				matched = true
+			}
+		}
+		if !matched {
+			return left
+		}
+		this.next()
+		right := this.parseBinary(level + 1)
+		switch token.text {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left = int64(uint64(left) << uint64(right&0x3f))
+		case ">>":
+			left >>= uint64(right & 0x3f)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				if this.err == nil { // a zero from an undefined symbol isn't an error
+					panic(errorAt(token.text, "Division by zero"))
+				}
+				return 0
+			}
+			if token.text == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (this *exprParser) parseUnary() int64 {
+	token := this.next()
+	switch token.class {
+	case ET_NUM:
+		return token.value
+	case ET_SYMBOL:
+		val, err := this.lookup(token.text)
+		if err != nil {
+			this.fail(err)
+			return 0
+		}
+		return val
+	case ET_OP:
+		switch token.text {
+		case "-":
+			return -this.parseUnary()
+		case "+":
+			return this.parseUnary()
+		case "~":
+			return ^this.parseUnary()
+		case "%hi": // adjusted for the sign extension of %lo
+			return ((this.parseUnary() + 0x8000) >> 16) & 0xffff
+		case "%lo":
+			return this.parseUnary() & 0xffff
+		case "(":
+			val := this.parseBinary(0)
+			if closing := this.next(); closing.text != ")" || closing.class != ET_OP {
+				panic(errorAt(closing.text, "Missing ) in expression"))
+			}
+			return val
+		}
+	}
+	if token.class == ET_END {
+		panic(errorAt("", "Unexpected end of expression"))
+	}
+	panic(errorAt(token.text, "Unexpected %s in expression", token.text))
+}
+
+// evalExpr evaluates the integer expression, the error is undefinedError if a symbol is unknown.
+// Syntax errors are raised as panics like other parsing errors.
+func evalExpr(str string, lookup SymbolLookup) (int64, error) {
+	parser := exprParser{tokenizeExpr(str), 0, lookup, nil}
+	val := parser.parseBinary(0)
+	if token := parser.peek(); token.class != ET_END {
+		panic(errorAt(token.text, "Unexpected %s in expression", token.text))
+	}
+	return val, parser.err
+}
+
+// isConstExpr checks whether the expression is a literal without any symbol
+func isConstExpr(str string) bool {
+	for _, token := range tokenizeExpr(str) {
+		if token.class == ET_SYMBOL {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package assembler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const MAX_INCLUDE_DEPTH = 32
+
+type includer struct {
+	dirs  []string
+	stack []string // absolute paths of the files being included
+	diags *Diagnostics
+}
+
+func readSourceFile(path string) ([]string, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.Split(string(bytes), "\n")
+	if len(content) > 0 && content[len(content)-1] == "" {
+		content = content[:len(content)-1]
+	}
+	for i, str := range content {
+		content[i] = strings.TrimRight(str, "\r")
+	}
+	return content, nil
+}
+
+// find the file relative to the including file first, then the search directories
+func (this *includer) resolve(name string, from string) (string, bool) {
+	if filepath.IsAbs(name) {
+		_, err := os.Stat(name)
+		return name, err == nil
+	}
+	candidates := make([]string, 0, len(this.dirs)+1)
+	if from != "" {
+		candidates = append(candidates, filepath.Join(filepath.Dir(from), name))
+	} else {
+		candidates = append(candidates, name)
+	}
+	for _, dir := range this.dirs {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+	return name, false
+}
+
+func (this *includer) expand(content []SourceLine) []SourceLine {
+	result := make([]SourceLine, 0, len(content))
+	for _, raw := range content {
+		line := trimSourceLine(raw)
+		directive, rem := getDirective(line.Text)
+		if directive != "include" {
+			result = append(result, raw)
+			continue
+		}
+		if len(rem) < 2 || !strings.HasPrefix(rem, "\"") || !strings.HasSuffix(rem, "\"") {
+			this.diags.errorf(line, rem, "Invalid include file name: %s", rem)
+			continue
+		}
+		name := rem[1 : len(rem)-1]
+		path, ok := this.resolve(name, line.File)
+		if !ok {
+			this.diags.errorf(line, rem, "Include file not found: %s", name)
+			continue
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		cycle := false
+		for _, including := range this.stack {
+			if including == abs {
+				cycle = true
+			}
+		}
+		if cycle {
+			this.diags.errorf(line, rem, "Include cycle: %s -> %s", strings.Join(this.stack, " -> "), abs)
+			continue
+		}
+		if len(this.stack) >= MAX_INCLUDE_DEPTH {
+			this.diags.errorf(line, rem, "Include is too deep: %s", name)
+			continue
+		}
+		lines, err := readSourceFile(path)
+		if err != nil {
+			this.diags.errorf(line, rem, "File %s reading error: %v", name, err)
+			continue
+		}
+		this.stack = append(this.stack, abs)
+		result = append(result, this.expand(NewSource(path, lines))...)
+		this.stack = this.stack[:len(this.stack)-1]
+	}
+	return result
+}
+
+// ExpandIncludes replaces the .include "file" lines with the content of the files
+func ExpandIncludes(content []SourceLine, dirs []string, diags *Diagnostics) []SourceLine {
+	stack := make([]string, 0)
+	if len(content) > 0 && content[0].File != "" {
+		if abs, err := filepath.Abs(content[0].File); err == nil {
+			stack = append(stack, abs)
+		}
+	}
+	expander := includer{dirs, stack, diags}
+	return expander.expand(content)
+}
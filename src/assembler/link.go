@@ -0,0 +1,207 @@
+package assembler
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+
+	"../instruction"
+)
+
+type linkedSymbol struct {
+	address uint32
+	object  string
+}
+
+// the place of a section of an object in the output
+type placement struct {
+	base uint32
+	data []uint8 // the slice of the output section
+}
+
+func (this *Diagnostics) linkErrorf(object string, format string, args ...interface{}) {
+	*this = append(*this, Diagnostic{Position{File: object}, SEVERITY_ERROR, fmt.Sprintf(format, args...), nil})
+}
+
+// patch the field by the relocation, the target is S+A and place is P
+func applyRelocation(field []uint8, reloc Relocation, target uint32, place uint32, order binary.ByteOrder) error {
+	word := order.Uint32(field)
+	switch reloc.Type {
+	case elf.R_MIPS_32:
+		word = target
+	case elf.R_MIPS_26:
+		if target&3 != 0 || !jumpInRange(target, place+4) {
+			return fmt.Errorf("jump target 0x%08x is out of the 256MB region of 0x%08x", target, place)
+		}
+		word = word&^0x3ffffff | (target>>2)&0x3ffffff
+	case elf.R_MIPS_HI16:
+		word = word&^0xffff | ((target+0x8000)>>16)&0xffff
+	case elf.R_MIPS_LO16:
+		word = word&^0xffff | target&0xffff
+	case elf.R_MIPS_PC16:
+		offset := int32(target-place) >> 2
+		if target&3 != 0 || offset < -0x8000 || offset > 0x7fff {
+			return fmt.Errorf("branch target 0x%08x is out of range from 0x%08x", target+4, place)
+		}
+		word = word&^0xffff | uint32(offset)&0xffff
+	default:
+		return fmt.Errorf("unsupported relocation %v", reloc.Type)
+	}
+	order.PutUint32(field, word)
+	return nil
+}
+
+// Link concatenates the sections of the same names of the objects in order, places them
+// from the bases in config as the assembler does, resolves the global symbols across
+// the objects and applies the relocations
+func Link(objects []Object, config AssembleConfig, size int32) ([]instruction.Instruction, AssembleResult, error) {
+	diags := make(Diagnostics, 0)
+	config.Relocatable = false
+	names := make([]string, 0)
+	flags := make(map[string]string)
+	outputs := make(map[string][]uint8)
+	offsets := make([]map[string]uint32, len(objects))
+	endianness := map[bool]string{false: "little-endian", true: "big-endian"}
+	for i, obj := range objects {
+		if obj.BigEndian != config.BigEndian {
+			diags.linkErrorf(obj.Name, "The object is %s, but the output is %s", endianness[obj.BigEndian], endianness[config.BigEndian])
+		}
+		if obj.ISARevision > config.revision() {
+			diags.linkErrorf(obj.Name, "The object is MIPS32 release %d, but the output is release %d", obj.ISARevision, config.revision())
+		}
+		offsets[i] = make(map[string]uint32)
+		for _, sec := range obj.Sections {
+			out, exists := outputs[sec.Name]
+			if !exists {
+				names = append(names, sec.Name)
+				flags[sec.Name] = sec.Flags
+			}
+			for uint32(len(out))%4 != 0 {
+				out = append(out, 0)
+			}
+			offsets[i][sec.Name] = uint32(len(out))
+			outputs[sec.Name] = append(out, sec.Data...)
+		}
+	}
+	codeNames, dataNames := sortSections(names, flags)
+	starts := make(map[string]uint32)
+	images := make([]SectionImage, 0, len(names))
+	for _, chain := range [][]string{codeNames, dataNames} {
+		follow := config.Text
+		if len(chain) > 0 && !isCodeSection(flags[chain[0]]) {
+			follow = config.Data
+		}
+		for _, name := range chain {
+			starts[name] = sectionBase(config, name, follow)
+			follow = starts[name] + uint32(len(outputs[name]))
+			images = append(images, SectionImage{name, flags[name], starts[name], outputs[name], nil})
+		}
+	}
+	realSize := uint32(0)
+	if size > 0 {
+		realSize = uint32(size)
+	}
+	memories := layoutMemories(config, realSize)
+	checkLayout(images, memories, func(sec SectionImage, format string, args ...interface{}) {
+		object := ""
+		for _, obj := range objects { // the last one placed in the section
+			if obj.sectionData(sec.Name) != nil {
+				object = obj.Name
+			}
+		}
+		diags.linkErrorf(object, format, args...)
+	})
+	places := make([]map[string]placement, len(objects))
+	for i, obj := range objects {
+		places[i] = make(map[string]placement)
+		for _, sec := range obj.Sections {
+			offset := offsets[i][sec.Name]
+			places[i][sec.Name] = placement{starts[sec.Name] + offset, outputs[sec.Name][offset : offset+uint32(len(sec.Data))]}
+		}
+	}
+
+	// the symbols defined in the objects, the globals are shared
+	globals := make(map[string]linkedSymbol)
+	symbols := make([]Symbol, 0)
+	for i, obj := range objects {
+		for _, symbol := range obj.Symbols {
+			if symbol.Kind == SYMBOL_EXTERN {
+				continue
+			}
+			if symbol.Kind == SYMBOL_LABEL {
+				symbol.Address += places[i][symbol.Segment].base
+			}
+			if symbol.Global {
+				if defined, exists := globals[symbol.Name]; exists {
+					diags.linkErrorf(obj.Name, "Symbol %s is also defined in %s", symbol.Name, defined.object)
+					continue
+				}
+				globals[symbol.Name] = linkedSymbol{symbol.Address, obj.Name}
+			}
+			symbols = append(symbols, symbol)
+		}
+	}
+	sortSymbols(symbols)
+
+	for i, obj := range objects {
+		for _, sec := range obj.Sections {
+			place := places[i][sec.Name]
+			for _, reloc := range sec.Relocs {
+				target := places[i][reloc.Section].base
+				if reloc.Symbol != "" {
+					defined, exists := globals[reloc.Symbol]
+					if !exists {
+						diags.linkErrorf(obj.Name, "Undefined symbol %s referenced at 0x%08x", reloc.Symbol, place.base+reloc.Offset)
+						continue
+					}
+					target = defined.address
+				}
+				if int(reloc.Offset)+4 > len(place.data) {
+					diags.linkErrorf(obj.Name, "Relocation at 0x%x is out of .%s", reloc.Offset, sec.Name)
+					continue
+				}
+				err := applyRelocation(place.data[reloc.Offset:], reloc, target+uint32(reloc.Addend), place.base+reloc.Offset, config.ByteOrder())
+				if err != nil {
+					diags.linkErrorf(obj.Name, "Relocation at 0x%08x: %v", place.base+reloc.Offset, err)
+				}
+			}
+		}
+	}
+
+	instrs := make([]instruction.Instruction, 0, len(outputs["text"])>>2)
+	for i := 0; i+4 <= len(outputs["text"]); i += 4 {
+		instrs = append(instrs, instruction.Parse(config.ByteOrder().Uint32(outputs["text"][i:])))
+	}
+	entries := make(map[string]uint32)
+	for name, defined := range globals {
+		entries[name] = defined.address
+	}
+	result := AssembleResult{
+		Data:        Segment{config.Data, config.Data + uint32(len(outputs["data"]))},
+		Text:        Segment{config.Text, config.Text + uint32(len(outputs["text"]))},
+		Bin:         make([]uint8, 0),
+		Diagnostics: diags,
+		Listing:     make([]ListingLine, 0),
+		Symbols:     symbols,
+		Sections:    images,
+		Entry:       findEntry(entries, config.Text),
+		BigEndian:   config.BigEndian,
+		ISARevision: config.revision(),
+	}
+	if config.Harvard {
+		if !diags.HasError() {
+			buildImages(images, memories)
+		}
+		result.Memories = memories
+	} else if size > 0 {
+		result.Full = Segment{0, realSize}
+		if !diags.HasError() {
+			buildImages(images, memories)
+			result.Bin = memories[0].Bin
+		}
+	}
+	if diags.HasError() {
+		return instrs, result, diags
+	}
+	return instrs, result, nil
+}
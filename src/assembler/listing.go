@@ -0,0 +1,21 @@
+package assembler
+
+import (
+	"../instruction"
+)
+
+// ListingLine ties a source line to what is assembled from it
+type ListingLine struct {
+	Source  SourceLine
+	Address uint32
+	Data    []uint8                   // the bytes of a data line
+	Instrs  []instruction.Instruction // the instructions of a text line, more than one for pseudo-instructions
+}
+
+// fill the bytes of the data lines after the fixups are resolved, base is the address of data[0]
+func fillListingData(listing []ListingLine, data []uint8, base uint32) {
+	for i := range listing {
+		start := listing[i].Address - base
+		copy(listing[i].Data, data[start:start+uint32(len(listing[i].Data))])
+	}
+}
@@ -0,0 +1,237 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const MAX_MACRO_DEPTH = 32
+
+type macro struct {
+	name   string
+	params []string
+	body   []SourceLine
+	line   SourceLine
+}
+
+type macroExpander struct {
+	macros map[string]*macro
+	count  int
+	diags  *Diagnostics
+}
+
+func getDirective(str string) (string, string) {
+	if !strings.HasPrefix(str, ".") {
+		return "", str
+	}
+	fields := strings.Fields(str[1:])
+	if len(fields) == 0 {
+		return "", str
+	}
+	return fields[0], strings.Trim(str[1+len(fields[0]):], " \t")
+}
+
+// split by the commas which are not in parentheses or quotes
+func splitArgs(str string) []string {
+	result := make([]string, 0)
+	if strings.Trim(str, " \t") == "" {
+		return result
+	}
+	depth, quote, last := 0, byte(0), 0
+	for i := 0; i < len(str); i++ {
+		switch chr := str[i]; {
+		case quote != 0 && chr == '\\':
+			i++
+		case quote != 0 && chr == quote:
+			quote = 0
+		case quote == 0 && (chr == '"' || chr == '\''):
+			quote = chr
+		case quote == 0 && chr == '(':
+			depth++
+		case quote == 0 && chr == ')':
+			depth--
+		case quote == 0 && depth == 0 && chr == ',':
+			result = append(result, strings.Trim(str[last:i], " \t"))
+			last = i + 1
+		}
+	}
+	return append(result, strings.Trim(str[last:], " \t"))
+}
+
+// parse "name arg1, arg2" or "name(arg1, arg2)"
+func parseMacroCall(str string) (string, []string) {
+	ind := strings.IndexAny(str, " \t(")
+	if ind == -1 {
+		return str, []string{}
+	}
+	name, rem := str[:ind], strings.Trim(str[ind:], " \t")
+	if strings.HasPrefix(rem, "(") && strings.HasSuffix(rem, ")") {
+		rem = rem[1 : len(rem)-1]
+	}
+	return name, splitArgs(rem)
+}
+
+func (this *macroExpander) define(line SourceLine, content []SourceLine) *macro {
+	_, rem := getDirective(line.Text)
+	name, params := parseMacroCall(rem)
+	if !symbolRegex.MatchString(name) {
+		this.diags.errorf(line, name, "Invalid macro name: %s", name)
+		return nil
+	}
+	for i, param := range params {
+		param = strings.TrimLeft(param, "%\\")
+		if !symbolRegex.MatchString(param) {
+			this.diags.errorf(line, param, "Invalid macro parameter: %s", param)
+			return nil
+		}
+		if param == "hi" || param == "lo" { // %hi(label) and %lo(label) in the body are kept
+			this.diags.errorf(line, param, "Macro parameter %s clashes with %%%s()", param, param)
+			return nil
+		}
+		params[i] = param
+	}
+	if _, exists := this.macros[name]; exists {
+		this.diags.errorf(line, name, "Macro %s has been defined.", name)
+	}
+	result := &macro{name, params, content, line}
+	this.macros[name] = result
+	return result
+}
+
+func (this *macroExpander) expandOne(mac *macro, args []string, line SourceLine) []SourceLine {
+	if len(args) != len(mac.params) {
+		this.diags.errorf(line, mac.name, "Macro %s expects %d arguments, but got %d", mac.name, len(mac.params), len(args))
+		return []SourceLine{}
+	}
+	// %name or \name as a whole identifier, so that %ab isn't replaced as %a
+	values := make(map[string]string, len(args))
+	names := make([]string, 0, len(args))
+	for i, param := range mac.params {
+		values[param] = args[i]
+		names = append(names, regexp.QuoteMeta(param))
+	}
+	paramRegex := regexp.MustCompile(`[\\%](` + strings.Join(names, "|") + `)\b`)
+	replaceParams := func(text string) string {
+		if len(names) == 0 {
+			return text
+		}
+		return paramRegex.ReplaceAllStringFunc(text, func(match string) string { return values[match[1:]] })
+	}
+
+	// labels defined in the body are renamed to be unique in each expansion
+	labels := make(map[string]bool)
+	for _, bodyLine := range mac.body {
+		lineLabels, _ := splitLabels(bodyLine.Text)
+		for _, label := range lineLabels {
+			labels[label] = true
+		}
+	}
+	suffix := fmt.Sprintf("_M%d", this.count)
+	this.count++
+
+	invocation := line
+	result := make([]SourceLine, 0, len(mac.body))
+	for _, bodyLine := range mac.body {
+		// the labels are renamed before the arguments come in, so the ones of the caller are kept
+		bodyLine.Text = replaceParams(renameLabels(bodyLine.Text, labels, suffix))
+		bodyLine.Invocation = &invocation
+		result = append(result, bodyLine)
+	}
+	return result
+}
+
+// renameLabels appends the suffix to the symbols which are labels, but not to the ones in
+// the string and char literals, the registers like $t0 and the parameters like %t0
+func renameLabels(text string, labels map[string]bool, suffix string) string {
+	var result strings.Builder
+	quote := byte(0)
+	for i := 0; i < len(text); {
+		chr := text[i]
+		switch {
+		case quote != 0 && chr == '\\' && i+1 < len(text):
+			result.WriteString(text[i : i+2])
+			i += 2
+			continue
+		case quote != 0 && chr == quote:
+			quote = 0
+		case quote == 0 && (chr == '"' || chr == '\''):
+			quote = chr
+		case quote == 0 && isSymbolChar(chr):
+			end := i
+			for end < len(text) && isSymbolChar(text[end]) {
+				end++
+			}
+			word := text[i:end]
+			if labels[word] && isSymbolStart(chr) && (i == 0 || strings.IndexByte("$%\\", text[i-1]) == -1) {
+				word += suffix
+			}
+			result.WriteString(word)
+			i = end
+			continue
+		}
+		result.WriteByte(chr)
+		i++
+	}
+	return result.String()
+}
+
+func (this *macroExpander) expand(content []SourceLine, depth int) []SourceLine {
+	result := make([]SourceLine, 0, len(content))
+	for i := 0; i < len(content); i++ {
+		line := trimSourceLine(content[i])
+		directive, _ := getDirective(line.Text)
+		switch directive {
+		case "macro":
+			if depth > 0 {
+				this.diags.errorf(line, ".macro", "Macro can't be defined in a macro")
+				continue
+			}
+			end := i + 1
+			for ; end < len(content); end++ {
+				name, _ := getDirective(trimSourceLine(content[end]).Text)
+				if name == "end_macro" || name == "endm" {
+					break
+				} else if name == "macro" {
+					this.diags.errorf(trimSourceLine(content[end]), ".macro", "Nested macro definition")
+				}
+			}
+			if end == len(content) {
+				this.diags.errorf(line, ".macro", "Macro without .end_macro")
+			}
+			body := make([]SourceLine, 0, end-i)
+			for _, bodyLine := range content[i+1 : end] {
+				body = append(body, trimSourceLine(bodyLine))
+			}
+			this.define(line, body)
+			i = end
+			continue
+		case "end_macro", "endm":
+			this.diags.errorf(line, line.Text, "%s without .macro", line.Text)
+			continue
+		}
+		labels, call := splitLabels(line.Text)
+		name, args := parseMacroCall(call)
+		if mac, ok := this.macros[name]; ok {
+			if depth >= MAX_MACRO_DEPTH {
+				this.diags.errorf(line, name, "Macro expansion is too deep: %s", name)
+				continue
+			}
+			if len(labels) > 0 { // the labels of the invocation stay on a line of their own
+				labelLine := line
+				labelLine.Text = strings.TrimRight(line.Text[:len(line.Text)-len(call)], " \t")
+				result = append(result, labelLine)
+			}
+			result = append(result, this.expand(this.expandOne(mac, args, line), depth+1)...)
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// ExpandMacros collects the macro definitions and replaces the invocations with the bodies
+func ExpandMacros(content []SourceLine, diags *Diagnostics) []SourceLine {
+	expander := macroExpander{make(map[string]*macro), 0, diags}
+	return expander.expand(content, 0)
+}
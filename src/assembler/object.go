@@ -0,0 +1,224 @@
+package assembler
+
+import (
+	"debug/elf"
+	"strings"
+)
+
+// the linkage of the symbols declared by .globl/.global and .extern
+type linkage struct {
+	globals map[string]SourceLine
+	externs map[string]SourceLine
+	names   []string // the externs in the order of declaration
+}
+
+func isLinkageDirective(name string) bool {
+	return name == "globl" || name == "global" || name == "extern"
+}
+
+// collectLinkage takes the .globl/.extern lines out, they may list several symbols
+func collectLinkage(content []SourceLine, diags *Diagnostics) ([]SourceLine, *linkage) {
+	result := make([]SourceLine, 0, len(content))
+	link := &linkage{make(map[string]SourceLine), make(map[string]SourceLine), make([]string, 0)}
+	for _, raw := range content {
+		line := trimSourceLine(raw)
+		directive, rem := getDirective(line.Text)
+		if !isLinkageDirective(directive) {
+			result = append(result, raw)
+			continue
+		}
+		diags.guard(line, func() {
+			if strings.Trim(rem, " \t") == "" {
+				panic(errorAt(line.Text, "Expect symbol names after .%s", directive))
+			}
+			for _, name := range splitArgs(rem) {
+				name = strings.Trim(name, " \t")
+				if !symbolRegex.MatchString(name) {
+					panic(errorAt(name, "Invalid symbol name: %s", name))
+				}
+				if directive == "extern" {
+					if _, exists := link.externs[name]; !exists {
+						link.externs[name] = line
+						link.names = append(link.names, name)
+					}
+				} else {
+					link.globals[name] = line
+				}
+			}
+		})
+	}
+	return result, link
+}
+
+// Relocation patches a field of a section when the sections are laid out by the linker
+type Relocation struct {
+	Offset  uint32     // the offset of the patched word in the section
+	Type    elf.R_MIPS // R_MIPS_32, R_MIPS_26, R_MIPS_HI16, R_MIPS_LO16 or R_MIPS_PC16
+	Symbol  string     // the external symbol, or empty for the start of Section
+	Section string
+	Addend  int32
+}
+
+// Object is the content of a relocatable object file
+type Object struct {
+	Name        string
+	Sections    []SectionImage
+	Symbols     []Symbol // the symbols of kind SYMBOL_EXTERN are undefined
+	BigEndian   bool
+	ISARevision int
+}
+
+// Object gives the relocatable object of the result assembled with AssembleConfig.Relocatable
+func (this AssembleResult) Object(name string) Object {
+	return Object{name, this.Sections, this.Symbols, this.BigEndian, this.ISARevision}
+}
+
+// the shift of the labels of one section for the relocation analysis
+const RELOC_SHIFT = 0x01000000
+
+// relocator finds the relocations of the fields referring to labels in a relocatable object,
+// the sections start at 0 so the value of a label is its offset in the section
+type relocator struct {
+	sections map[string]string // the section of each label, "" for the externs
+	lookup   SymbolLookup
+	relocs   map[string][]Relocation
+}
+
+func newRelocator(lookup SymbolLookup) *relocator {
+	return &relocator{make(map[string]string), lookup, make(map[string][]Relocation)}
+}
+
+func (this *relocator) addLabels(labels map[string]uint32, section string) {
+	for name := range labels {
+		this.sections[name] = section
+	}
+}
+
+// split the %hi(...) or %lo(...) around the whole expression
+func splitRelocOperator(expr string) (string, string) {
+	expr = strings.Trim(expr, " \t")
+	if !(strings.HasPrefix(expr, "%hi(") || strings.HasPrefix(expr, "%lo(")) || !strings.HasSuffix(expr, ")") {
+		return "", expr
+	}
+	depth := 0
+	for i := 3; i < len(expr); i++ {
+		if expr[i] == '(' {
+			depth++
+		} else if expr[i] == ')' {
+			depth--
+			if depth == 0 && i != len(expr)-1 {
+				return "", expr // like %hi(a)+%lo(b)
+			}
+		}
+	}
+	return expr[:3], expr[4 : len(expr)-1]
+}
+
+// analyze finds the target of the expression, which is a label plus a constant.
+// Each section, or extern, is moved alone to see how the value depends on it.
+// ok is false if the value doesn't depend on where the sections are.
+func (this *relocator) analyze(expr string) (op string, symbol string, section string, addend int32, ok bool) {
+	op, inner := splitRelocOperator(expr)
+	base, err := evalExpr(inner, this.lookup)
+	if err != nil {
+		panic(err)
+	}
+	// the externs are grouped by their names and the others by their sections
+	groups := make([]string, 0)
+	groupOf := func(name string) (string, bool) {
+		section, isLabel := this.sections[name]
+		if isLabel && section == "" {
+			return "extern " + name, true
+		}
+		return section, isLabel
+	}
+	for _, token := range tokenizeExpr(inner) {
+		if group, isLabel := groupOf(token.text); token.class == ET_SYMBOL && isLabel {
+			exists := false
+			for _, g := range groups {
+				exists = exists || g == group
+			}
+			if !exists {
+				groups = append(groups, group)
+			}
+		}
+	}
+	found := ""
+	for _, group := range groups {
+		shifted := func(name string) (int64, error) {
+			val, err := this.lookup(name)
+			if g, isLabel := groupOf(name); isLabel && g == group {
+				val += RELOC_SHIFT
+			}
+			return val, err
+		}
+		moved, _ := evalExpr(inner, shifted)
+		switch moved - base {
+		case 0: // like the difference of two labels
+		case RELOC_SHIFT:
+			if found != "" {
+				panic(errorAt(expr, "Expression can't be relocated: %s", expr))
+			}
+			found = group
+		default:
+			panic(errorAt(expr, "Expression can't be relocated: %s", expr))
+		}
+	}
+	switch {
+	case found == "":
+		return "", "", "", 0, false
+	case strings.HasPrefix(found, "extern "):
+		return op, found[len("extern "):], "", int32(base), true
+	}
+	return op, "", found, int32(base), true
+}
+
+func (this *relocator) add(section string, reloc Relocation) {
+	this.relocs[section] = append(this.relocs[section], reloc)
+}
+
+// relocateText records the relocation of the instruction at addr of the code section, and makes
+// the fields of the relocated branches encodable as their targets aren't known yet
+func (this *relocator) relocateText(syntax InstructionSyntax, addr uint32, current string) {
+	for i, token := range syntax.args {
+		if token.class == TC_REG || token.symbol == "" {
+			continue
+		}
+		op, symbol, section, addend, ok := this.analyze(token.symbol)
+		if !ok {
+			continue
+		}
+		reloc := Relocation{addr, 0, symbol, section, addend}
+		switch {
+		case isJump(syntax) && op == "":
+			reloc.Type = elf.R_MIPS_26
+			syntax.args[i] = Token{TC_IMM, addr + 4, token.symbol}
+		case targetIndex(syntax) == i && op == "":
+			if section == current {
+				continue // relative in the same section
+			}
+			reloc.Type = elf.R_MIPS_PC16
+			reloc.Addend -= 4 // relative to the delay slot
+			syntax.args[i] = Token{TC_IMM, addr + 4, token.symbol}
+		case op == "%hi":
+			reloc.Type = elf.R_MIPS_HI16
+		case op == "%lo":
+			reloc.Type = elf.R_MIPS_LO16
+		default:
+			panic(errorAt(token.symbol, "Label in a 16-bit field can't be relocated, use %%hi/%%lo: %s", token.symbol))
+		}
+		this.add(current, reloc)
+	}
+}
+
+// relocateData records the relocation of the fixup in the data section, only words can be relocated
+func (this *relocator) relocateData(fix dataFixup, current string) {
+	_, symbol, section, addend, ok := this.analyze(fix.expr)
+	if !ok {
+		return
+	}
+	if fix.size != 4 {
+		panic(errorAt(fix.expr, "Only .word can be relocated: %s", fix.expr))
+	}
+	this.add(current, Relocation{fix.addr, elf.R_MIPS_32, symbol, section, addend})
+}
@@ -0,0 +1,289 @@
+package assembler
+
+import (
+	"strconv"
+	"strings"
+
+	"../instruction"
+)
+
+// The MARS/SPIM pseudo-instruction library. All temporaries go through $at.
+
+var (
+	tokenZERO = Token{class: TC_REG, value: uint32(instruction.GPR_ZERO)}
+	tokenAT   = Token{class: TC_REG, value: uint32(instruction.GPR_AT)}
+	tokenRA   = Token{class: TC_REG, value: uint32(instruction.GPR_RA)}
+)
+
+func immToken(val uint32) Token {
+	return Token{class: TC_IMM, value: val}
+}
+
+func syn(symbol string, args ...Token) InstructionSyntax {
+	return InstructionSyntax{symbol, args}
+}
+
+// the token plus a constant offset
+func offsetToken(token Token, offset uint32) Token {
+	if token.class == TC_IMM {
+		return Token{TC_IMM, token.value + offset, token.symbol}
+	}
+	return Token{TC_SYMBOL, 0, "(" + token.symbol + ")+" + strconv.FormatUint(uint64(offset), 10)}
+}
+
+// li $at, imm, which is the same in every configuration
+func loadAT(token Token) []InstructionSyntax {
+	result, _ := textPreprocessOne(syn("li", tokenAT, token), AssembleConfig{})
+	return result
+}
+
+// the value as a register, loaded into $at if it isn't a register
+func asRegister(token Token) ([]InstructionSyntax, Token) {
+	if token.class == TC_REG {
+		return []InstructionSyntax{}, token
+	}
+	if token.class == TC_IMM && token.value == 0 {
+		return []InstructionSyntax{}, tokenZERO
+	}
+	return loadAT(token), tokenAT
+}
+
+// rd = lhs < rhs, op is slt or sltu, one of lhs and rhs may be an immediate
+func lessThan(op string, rd Token, lhs Token, rhs Token) []InstructionSyntax {
+	if lhs.class != TC_REG {
+		load, reg := asRegister(lhs)
+		return append(load, syn(op, rd, reg, rhs))
+	}
+	if fitsField16(rhs) {
+		return []InstructionSyntax{syn(strings.Replace(op, "slt", "slti", 1), rd, lhs, rhs)}
+	}
+	load, reg := asRegister(rhs)
+	return append(load, syn(op, rd, lhs, reg))
+}
+
+// rd = rs op rt, where rt may be an immediate which is loaded into $at
+func withRegister(op string, rd Token, rs Token, rt Token) []InstructionSyntax {
+	load, reg := asRegister(rt)
+	return append(load, syn(op, rd, rs, reg))
+}
+
+// the immediate fits the instruction itself, zeroExt for andi/ori/xori
+func fitsImm(token Token, zeroExt bool) bool {
+	if token.class != TC_IMM || isLoField(token) {
+		return true // symbols are checked at encoding, like %lo(label)
+	}
+	if zeroExt {
+		return token.value <= 0xffff
+	}
+	return fitsInt16(token.value)
+}
+
+// the expansions overwriting $at piece by piece can't take it as an operand
+func rejectAT(symbol string, regs []Token) {
+	for _, reg := range regs {
+		if reg.value == tokenAT.value {
+			panic(errorAt(reg.symbol, "%s can't take $at, which it uses as the temporary", symbol))
+		}
+	}
+}
+
+// the offset in a word of its byte of significance i, 0 for the least significant one
+func byteOffset(i uint32, config AssembleConfig) uint32 {
+	if config.BigEndian {
+		return 3 - i
+	}
+	return i
+}
+
+func isMemoryAccess(symbol string) bool {
+	switch symbol {
+	case "lb", "lbu", "lh", "lhu", "lw", "sb", "sh", "sw", "lwl", "lwr", "swl", "swr":
+		return true
+	}
+	return false
+}
+
+// textPreprocessLibrary expands the pseudo-instructions sharing the name or the
+// operand forms with the real ones, the others are kept for encoding
+func textPreprocessLibrary(syntax InstructionSyntax, config AssembleConfig) ([]InstructionSyntax, bool) {
+	args := syntax.args
+	switch syntax.symbol {
+	case "blt", "bltu", "bge", "bgeu", "bgt", "bgtu", "ble", "bleu":
+		if !(len(args) == 3 && args[0].class == TC_REG && args[2].class != TC_REG) {
+			break
+		}
+		op := "slt"
+		if syntax.symbol[len(syntax.symbol)-1] == 'u' {
+			op = "sltu"
+		}
+		var result []InstructionSyntax
+		switch syntax.symbol[:3] {
+		case "blt", "bge": // rs < rt
+			result = lessThan(op, tokenAT, args[0], args[1])
+		case "bgt", "ble": // rt < rs
+			result = lessThan(op, tokenAT, args[1], args[0])
+		}
+		branch := "bne"
+		if syntax.symbol[:3] == "bge" || syntax.symbol[:3] == "ble" {
+			branch = "beq"
+		}
+		return append(result, syn(branch, tokenAT, tokenZERO, args[2])), true
+	case "beq", "bne", "beql", "bnel":
+		if len(args) == 3 && args[0].class == TC_REG && args[1].class != TC_REG {
+			load, reg := asRegister(args[1])
+			return append(load, syn(syntax.symbol, args[0], reg, args[2])), true
+		}
+	case "seq", "sne":
+		if !(len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG) {
+			break
+		}
+		var result []InstructionSyntax
+		if args[2].class == TC_IMM && args[2].value <= 0xffff {
+			result = []InstructionSyntax{syn("xori", args[0], args[1], args[2])}
+		} else {
+			result = withRegister("xor", args[0], args[1], args[2])
+		}
+		if syntax.symbol == "seq" {
+			return append(result, syn("sltiu", args[0], args[0], immToken(1))), true
+		}
+		return append(result, syn("sltu", args[0], tokenZERO, args[0])), true
+	case "sgt", "sgtu", "sge", "sgeu", "sle", "sleu":
+		if !(len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG) {
+			break
+		}
+		op := "slt"
+		if syntax.symbol[len(syntax.symbol)-1] == 'u' {
+			op = "sltu"
+		}
+		var result []InstructionSyntax
+		switch syntax.symbol[:3] {
+		case "sgt", "sle": // rt < rs
+			result = lessThan(op, args[0], args[2], args[1])
+		case "sge": // rs < rt
+			result = lessThan(op, args[0], args[1], args[2])
+		}
+		if syntax.symbol[:3] == "sgt" {
+			return result, true
+		}
+		return append(result, syn("xori", args[0], args[0], immToken(1))), true
+	case "slt", "sltu":
+		if len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG && args[2].class != TC_REG {
+			return lessThan(syntax.symbol, args[0], args[1], args[2]), true
+		}
+	case "abs":
+		if !assertRR(args) {
+			break
+		}
+		return []InstructionSyntax{
+			syn("sra", tokenAT, args[1], immToken(31)),
+			syn("xor", args[0], args[1], tokenAT),
+			syn("subu", args[0], args[0], tokenAT),
+		}, true
+	case "div", "divu", "rem", "remu":
+		if !(len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG) {
+			break
+		}
+		op, move := syntax.symbol, "mflo"
+		if op[:3] == "rem" {
+			op, move = "div"+op[3:], "mfhi"
+		}
+		load, reg := asRegister(args[2])
+		return append(load, syn(op, args[1], reg), syn(move, args[0])), true
+	case "mul":
+		if len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG && args[2].class != TC_REG {
+			return withRegister("mul", args[0], args[1], args[2]), true
+		}
+	case "mulu":
+		if !(len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG) {
+			break
+		}
+		load, reg := asRegister(args[2])
+		return append(load, syn("multu", args[1], reg), syn("mflo", args[0])), true
+	case "addi", "addiu", "slti", "sltiu", "andi", "ori", "xori":
+		zeroExt := syntax.symbol == "andi" || syntax.symbol == "ori" || syntax.symbol == "xori"
+		if !assertRRI(args) || fitsImm(args[2], zeroExt) {
+			break
+		}
+		op := syntax.symbol[:len(syntax.symbol)-1] // the register form
+		if syntax.symbol == "addiu" || syntax.symbol == "sltiu" {
+			op = syntax.symbol[:len(syntax.symbol)-2] + "u"
+		}
+		return withRegister(op, args[0], args[1], args[2]), true
+	case "subi", "subiu":
+		if !(len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG && args[2].class != TC_REG) {
+			break
+		}
+		if neg := immToken(-args[2].value); args[2].class == TC_IMM && fitsInt16(neg.value) {
+			return []InstructionSyntax{syn("addi"+syntax.symbol[4:], args[0], args[1], neg)}, true
+		}
+		return withRegister("sub"+syntax.symbol[4:], args[0], args[1], args[2]), true
+	case "rol", "ror":
+		if !(len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG) {
+			break
+		}
+		first, second := "srl", "sll" // rol: (rs >> (32-n)) | (rs << n)
+		if syntax.symbol == "ror" {
+			first, second = "sll", "srl"
+		}
+		if args[2].class == TC_REG {
+			return []InstructionSyntax{
+				syn("subu", tokenAT, tokenZERO, args[2]),
+				syn(first+"v", tokenAT, args[1], tokenAT),
+				syn(second+"v", args[0], args[1], args[2]),
+				syn("or", args[0], args[0], tokenAT),
+			}, true
+		} else if args[2].class == TC_IMM {
+			n := args[2].value & 0x1f
+			return []InstructionSyntax{
+				syn(first, tokenAT, args[1], immToken((32-n)&0x1f)),
+				syn(second, args[0], args[1], immToken(n)),
+				syn("or", args[0], args[0], tokenAT),
+			}, true
+		}
+	case "ulw": // bytes from the most significant one, $at holds each byte
+		if !(assertRRRn(args) && args[0].value != args[1].value) {
+			break
+		}
+		rejectAT(syntax.symbol, args[:2])
+		rt, rs, off := args[0], args[1], args[2]
+		result := []InstructionSyntax{syn("lbu", rt, rs, offsetToken(off, byteOffset(3, config)))}
+		for i := 2; i >= 0; i-- {
+			result = append(result,
+				syn("sll", rt, rt, immToken(8)),
+				syn("lbu", tokenAT, rs, offsetToken(off, byteOffset(uint32(i), config))),
+				syn("or", rt, rt, tokenAT))
+		}
+		return result, true
+	case "usw":
+		if !assertRRRn(args) {
+			break
+		}
+		rejectAT(syntax.symbol, args[:2])
+		rt, rs, off := args[0], args[1], args[2]
+		result := []InstructionSyntax{syn("sb", rt, rs, offsetToken(off, byteOffset(0, config)))}
+		for i := uint32(1); i < 4; i++ {
+			result = append(result,
+				syn("srl", tokenAT, rt, immToken(i*8)),
+				syn("sb", tokenAT, rs, offsetToken(off, byteOffset(i, config))))
+		}
+		return result, true
+	default:
+		if !isMemoryAccess(syntax.symbol) {
+			return []InstructionSyntax{syntax}, true
+		}
+		if assertRRn(args) && !fitsField16(args[1]) { // lw $rt, label
+			return []InstructionSyntax{
+				syn("lui", tokenAT, hiToken(args[1])),
+				syn(syntax.symbol, args[0], tokenAT, loToken(args[1])),
+			}, true
+		} else if assertRRRn(args) && !fitsField16(args[2]) { // lw $rt, label($rs)
+			return []InstructionSyntax{
+				syn("lui", tokenAT, hiToken(args[2])),
+				syn("addu", tokenAT, tokenAT, args[1]),
+				syn(syntax.symbol, args[0], tokenAT, loToken(args[2])),
+			}, true
+		}
+		return []InstructionSyntax{syntax}, true
+	}
+	return []InstructionSyntax{syntax}, true // the real instruction, checked at encoding
+}
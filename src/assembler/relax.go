@@ -0,0 +1,177 @@
+package assembler
+
+// the relaxation levels of a branch or jump, which only grow between the passes
+const (
+	RELAX_NONE     = iota
+	RELAX_JUMP     // the inverted branch over j/jal
+	RELAX_REGISTER // lui/addiu $at and jr/jalr $at, for targets out of the 256MB region
+)
+
+// an instruction before the relaxation, by the index of the line and the index in its expansion
+type relaxKey struct {
+	line  int
+	index int
+}
+
+var invertedBranches = map[string]string{
+	"beq":    "bne",
+	"bne":    "beq",
+	"bgez":   "bltz",
+	"bltz":   "bgez",
+	"bgtz":   "blez",
+	"blez":   "bgtz",
+	"bgezal": "bltz",
+	"bltzal": "bgez",
+	// the branch-likely ones also skip their delay slot when the condition fails
+	"beql":    "bne",
+	"bnel":    "beq",
+	"bgezl":   "bltz",
+	"bltzl":   "bgez",
+	"bgtzl":   "blez",
+	"blezl":   "bgtz",
+	"bgezall": "bltz",
+	"bltzall": "bgez",
+}
+
+// the index of the target operand of branches and jumps, -1 for the others
+func targetIndex(syntax InstructionSyntax) int {
+	switch syntax.symbol {
+	case "beq", "bne", "beql", "bnel":
+		if len(syntax.args) == 3 {
+			return 2
+		}
+	case "bgez", "bgezal", "bgtz", "blez", "bltz", "bltzal", "bgezl", "bgezall", "bgtzl", "blezl", "bltzl", "bltzall":
+		if len(syntax.args) == 2 {
+			return 1
+		}
+	case "j", "jal":
+		if len(syntax.args) == 1 {
+			return 0
+		}
+	}
+	return -1
+}
+
+func isJump(syntax InstructionSyntax) bool {
+	return syntax.symbol == "j" || syntax.symbol == "jal"
+}
+
+// the branch-likely instructions only run their delay slots when they are taken
+func isLikely(syntax InstructionSyntax) bool {
+	switch syntax.symbol {
+	case "beql", "bnel", "bgezl", "bgezall", "bgtzl", "blezl", "bltzl", "bltzall":
+		return true
+	}
+	return false
+}
+
+func branchInRange(target uint32, nextPC uint32) bool {
+	offset := int32(target-nextPC) >> 2
+	return offset >= -0x8000 && offset <= 0x7fff
+}
+
+func jumpInRange(target uint32, nextPC uint32) bool {
+	return target&0xf0000000 == nextPC&0xf0000000
+}
+
+// relaxBranch expands the branch at addr, the delay slot of the original one
+// becomes the delay slot of the final jump, so the behavior is kept
+func relaxBranch(syntax InstructionSyntax, level int, addr uint32) []InstructionSyntax {
+	ind := targetIndex(syntax)
+	target := syntax.args[ind]
+	// bgezal and the like link even when they aren't taken, so they link before the
+	// inverted branch, and only jal links in the jump
+	link := linkRegister(syntax) != -1
+	var jump []InstructionSyntax
+	if level == RELAX_JUMP && !isJump(syntax) {
+		jump = []InstructionSyntax{syn("j", target)}
+	} else {
+		jump = []InstructionSyntax{
+			syn("lui", tokenAT, hiToken(target)),
+			syn("addiu", tokenAT, tokenAT, loToken(target)),
+			syn("jr", tokenAT),
+		}
+		if link && isJump(syntax) {
+			jump[2] = syn("jalr", tokenAT)
+		}
+	}
+	if isJump(syntax) {
+		return jump
+	}
+	result := []InstructionSyntax{}
+	start := addr
+	if link {
+		addr += 8
+	}
+	// skip the nop and the jump if the condition fails, and the delay slot for the branch-likely ones
+	slot := addr + uint32(len(jump)+2)*4
+	over := slot
+	if isLikely(syntax) {
+		over += 4
+	}
+	if link {
+		// bltzal $zero is never taken but links the address after its own delay slot,
+		// which moves the link to the address after the delay slot of the original branch
+		result = append(result,
+			syn("bltzal", tokenZERO, immToken(start+8)),
+			syn("addiu", tokenRA, tokenRA, immToken(slot+4-(start+8))))
+	}
+	args := append(append([]Token{}, syntax.args[:ind]...), immToken(over))
+	result = append(result,
+		syn(invertedBranches[syntax.symbol], args...),
+		syn("sll", tokenZERO, tokenZERO, immToken(0)))
+	return append(result, jump...)
+}
+
+func evalTarget(token Token, lookup SymbolLookup) (target uint32, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	if token.class == TC_IMM {
+		return token.value, true
+	} else if token.class != TC_SYMBOL {
+		return 0, false
+	}
+	val, err := evalExpr(token.symbol, lookup)
+	return uint32(val), err == nil
+}
+
+func mergeSymbols(tables ...map[string]uint32) map[string]uint32 {
+	result := make(map[string]uint32)
+	for _, table := range tables {
+		for k, v := range table {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// relaxPass raises the levels of the branches out of range in the layout,
+// returns whether the layout should be done again
+func relaxPass(syntaxs []InstructionSyntax, keys []relaxKey, relax map[relaxKey]int, start uint32, lookup SymbolLookup) bool {
+	changed := false
+	for i, syntax := range syntaxs {
+		ind := targetIndex(syntax)
+		if ind == -1 || relax[keys[i]] == RELAX_REGISTER {
+			continue
+		}
+		target, ok := evalTarget(syntax.args[ind], lookup)
+		if !ok {
+			continue // reported when encoding
+		}
+		nextPC := start + uint32(i+1)*4
+		if target&3 != 0 {
+			continue
+		}
+		if isJump(syntax) && !jumpInRange(target, nextPC) {
+			relax[keys[i]] = RELAX_REGISTER
+			changed = true
+		} else if !isJump(syntax) && !branchInRange(target, nextPC) {
+			relax[keys[i]]++
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,116 @@
+package assembler
+
+import (
+	"strings"
+
+	"../instruction"
+)
+
+// In the reorder mode of .set reorder, the default, the assembler owns the delay slots: each branch
+// or jump gets the instruction before it moved into its slot when that is safe, or a nop. In the
+// noreorder mode the instruction written after a branch is its delay slot.
+
+// the mode of the line if it is .set reorder or .set noreorder
+func reorderMode(text string) (reorder bool, ok bool) {
+	directive, rem := getDirective(text)
+	if directive != "set" {
+		return false, false
+	}
+	switch strings.ToLower(strings.Trim(rem, " \t")) {
+	case "reorder":
+		return true, true
+	case "noreorder":
+		return false, true
+	}
+	return false, false
+}
+
+func reorderLine(line SourceLine, reorder bool) SourceLine {
+	line.Text = ".set noreorder"
+	if reorder {
+		line.Text = ".set reorder"
+	}
+	return line
+}
+
+// the instructions which may be moved into a delay slot, by whether the first operand is written
+var slotCandidates = map[string]bool{
+	"add": true, "addu": true, "sub": true, "subu": true, "mul": true,
+	"and": true, "or": true, "nor": true, "xor": true, "slt": true, "sltu": true,
+	"addi": true, "addiu": true, "andi": true, "ori": true, "xori": true, "slti": true, "sltiu": true,
+	"sll": true, "sra": true, "srl": true, "sllv": true, "srav": true, "srlv": true,
+	"lui": true, "mfhi": true, "mflo": true,
+	"movn": true, "movz": true, "clo": true, "clz": true,
+	"ext": true, "ins": true, "seb": true, "seh": true, "wsbh": true, "rotr": true, "rotrv": true, "rdhwr": true,
+	"lb": true, "lbu": true, "lh": true, "lhu": true, "lw": true, "lwl": true, "lwr": true,
+	"sb": false, "sh": false, "sw": false, "swl": false, "swr": false,
+	"mult": false, "multu": false, "div": false, "divu": false, "mthi": false, "mtlo": false,
+	"madd": false, "maddu": false, "msub": false, "msubu": false,
+}
+
+// a load whose value is only seen from the second instruction after it in the MIPS I load delay
+func isLoad(syntax InstructionSyntax) bool {
+	switch syntax.symbol {
+	case "lb", "lbu", "lh", "lhu", "lw", "lwl", "lwr":
+		return true
+	}
+	return false
+}
+
+func hasDelaySlot(syntax InstructionSyntax) bool {
+	return targetIndex(syntax) != -1 || syntax.symbol == "jr" || syntax.symbol == "jalr"
+}
+
+func registers(args []Token) []uint32 {
+	result := make([]uint32, 0, len(args))
+	for _, arg := range args {
+		if arg.class == TC_REG {
+			result = append(result, arg.value)
+		}
+	}
+	return result
+}
+
+// the register the branch or jump links, or -1
+func linkRegister(syntax InstructionSyntax) int {
+	switch syntax.symbol {
+	case "jal", "bgezal", "bltzal", "bgezall", "bltzall":
+		return int(instruction.GPR_RA)
+	case "jalr":
+		if len(syntax.args) == 2 {
+			return int(syntax.args[0].value)
+		}
+		return int(instruction.GPR_RA)
+	}
+	return -1
+}
+
+// canFillSlot tells whether the instruction before the branch can be its delay slot instead:
+// the branch doesn't read the register it writes, and it doesn't touch the link register,
+// the slots of the branch-likely ones are skipped when not taken, so they are never filled
+func canFillSlot(prev InstructionSyntax, branch InstructionSyntax) bool {
+	writesFirst, ok := slotCandidates[prev.symbol]
+	if !ok || isLikely(branch) {
+		return false
+	}
+	used := registers(prev.args)
+	reads := registers(branch.args)
+	if branch.symbol == "jalr" && len(reads) == 2 {
+		reads = reads[1:] // the link register is written
+	}
+	if writesFirst && len(used) > 0 && used[0] != uint32(instruction.GPR_ZERO) {
+		for _, reg := range reads {
+			if reg == used[0] {
+				return false
+			}
+		}
+	}
+	if link := linkRegister(branch); link != -1 {
+		for _, reg := range used {
+			if reg == uint32(link) {
+				return false
+			}
+		}
+	}
+	return true
+}
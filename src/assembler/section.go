@@ -0,0 +1,332 @@
+package assembler
+
+import (
+	"sort"
+	"strings"
+
+	"../instruction"
+)
+
+// the flags of the sections as in .section: allocated, writable and executable
+const (
+	SECTION_ALLOC = "a"
+	SECTION_WRITE = "w"
+	SECTION_EXEC  = "x"
+)
+
+// the flags of the standard sections, the others are defined by .section
+var standardSections = map[string]string{
+	"text":   "ax",
+	"ktext":  "ax",
+	"data":   "aw",
+	"rodata": "a",
+	"kdata":  "aw",
+	"bss":    "aw",
+}
+
+// the order the sections without a base address follow each other,
+// the custom sections go before .bss in the order they appear
+var sectionOrder = []string{"text", "ktext", "data", "rodata", "kdata", "bss"}
+
+func isCodeSection(flags string) bool {
+	return strings.Contains(flags, SECTION_EXEC)
+}
+
+// section is a section being assembled
+type section struct {
+	name   string
+	flags  string
+	line   SourceLine // where it first appears
+	lines  []SourceLine
+	start  uint32
+	end    uint32
+	labels map[string]uint32
+	data   []uint8 // the encoded instructions for the code sections
+	// the data sections
+	fixups  []dataFixup
+	listing []ListingLine
+	// the code sections
+	layout textLayout
+	instrs []instruction.Instruction
+}
+
+func (this *section) image() SectionImage {
+	return SectionImage{this.name, this.flags, this.start, this.data, nil}
+}
+
+// parse ".section name, flags", the name may start with a dot and the flags may be quoted
+func parseSectionDirective(rem string) (string, string) {
+	args := splitArgs(rem)
+	if len(args) == 0 {
+		panic(errorAt(".section", "Expect a section name after .section"))
+	}
+	name := strings.TrimPrefix(strings.Trim(args[0], " \t"), ".")
+	if !symbolRegex.MatchString(name) {
+		panic(errorAt(args[0], "Invalid section name: %s", args[0]))
+	}
+	standard, isStandard := standardSections[name]
+	if len(args) == 1 {
+		if isStandard {
+			return name, standard
+		}
+		return name, SECTION_ALLOC + SECTION_WRITE
+	}
+	if len(args) > 2 {
+		panic(errorAt(args[2], "Unexpected argument of .section: %s", args[2]))
+	}
+	flags := strings.Trim(strings.Trim(args[1], " \t"), "\"")
+	for _, flag := range flags {
+		if !strings.ContainsRune(SECTION_ALLOC+SECTION_WRITE+SECTION_EXEC, flag) {
+			panic(errorAt(args[1], "Invalid section flags: %s, expect a, w and x", args[1]))
+		}
+	}
+	if !strings.Contains(flags, SECTION_ALLOC) {
+		flags = SECTION_ALLOC + flags
+	}
+	if isStandard && !sameFlags(flags, standard) {
+		panic(errorAt(args[1], "The flags of .%s are %s", name, standard))
+	}
+	return name, flags
+}
+
+func sameFlags(a string, b string) bool {
+	for _, flag := range SECTION_ALLOC + SECTION_WRITE + SECTION_EXEC {
+		if strings.ContainsRune(a, flag) != strings.ContainsRune(b, flag) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitSections trims the lines and groups them by the section directives in the order
+// of the first appearance, the lines before any directive are returned alone. The mode of
+// .set reorder and noreorder goes on across the sections, starting from reorder, so the
+// code sections get the lines of the mode where they go on in another one
+func splitSections(content []SourceLine, reorder bool, diags *Diagnostics) ([]*section, []SourceLine) {
+	result := make([]*section, 0)
+	byName := make(map[string]*section)
+	outside := make([]SourceLine, 0)
+	modes := make(map[string]bool) // the mode at the end of the code sections so far
+	var current *section
+	for _, line := range content {
+		line = trimSourceLine(line)
+		if len(line.Text) == 0 {
+			continue
+		}
+		if mode, ok := reorderMode(line.Text); ok {
+			reorder = mode
+			if current != nil && isCodeSection(current.flags) {
+				current.lines = append(current.lines, line)
+				modes[current.name] = mode
+			}
+			continue
+		}
+		directive, rem := getDirective(line.Text)
+		if directive == "" || isDataDirective(directive) {
+			if current == nil {
+				outside = append(outside, line)
+			} else {
+				current.lines = append(current.lines, line)
+			}
+			continue
+		}
+		diags.guard(line, func() {
+			name, flags := directive, standardSections[directive]
+			if directive == "section" {
+				name, flags = parseSectionDirective(rem)
+			} else if flags == "" {
+				panic(errorAt(line.Text, "No this section or directive: .%s", directive))
+			}
+			if sec, exists := byName[name]; exists {
+				if !sameFlags(sec.flags, flags) {
+					panic(errorAt(line.Text, "Section .%s is defined with flags %s", name, sec.flags))
+				}
+				current = sec
+			} else {
+				current = &section{name: name, flags: flags, line: line, lines: make([]SourceLine, 0), labels: make(map[string]uint32)}
+				byName[name] = current
+				result = append(result, current)
+				modes[name] = true
+			}
+			if isCodeSection(current.flags) && modes[name] != reorder {
+				current.lines = append(current.lines, reorderLine(line, reorder))
+				modes[name] = reorder
+			}
+		})
+	}
+	return result, outside
+}
+
+const DEFAULT_SEGMENT = ""
+
+// TrimSplitSegment gives the trimmed lines of each section by its name without the dot, like
+// "text" or "rodata", the lines before any section are in DEFAULT_SEGMENT
+func TrimSplitSegment(content []SourceLine) map[string][]SourceLine {
+	diags := make(Diagnostics, 0)
+	sections, outside := splitSections(content, true, &diags)
+	result := map[string][]SourceLine{DEFAULT_SEGMENT: outside}
+	for _, sec := range sections {
+		result[sec.name] = sec.lines
+	}
+	return result
+}
+
+func sectionRank(name string) int {
+	if name == "bss" { // after the custom sections, which rank before it
+		return len(sectionOrder)
+	}
+	for i, standard := range sectionOrder {
+		if name == standard {
+			return i
+		}
+	}
+	return len(sectionOrder) - 1
+}
+
+// sortSections gives the code sections and the others in the order of placement,
+// names are in the order of the first appearance
+func sortSections(names []string, flags map[string]string) ([]string, []string) {
+	sorted := append([]string{}, names...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sectionRank(sorted[i]) < sectionRank(sorted[j]) })
+	code, others := make([]string, 0), make([]string, 0)
+	for _, name := range sorted {
+		if isCodeSection(flags[name]) {
+			code = append(code, name)
+		} else {
+			others = append(others, name)
+		}
+	}
+	return code, others
+}
+
+// sectionBase is where the section starts, follow is the end of the previous one of its kind
+func sectionBase(config AssembleConfig, name string, follow uint32) uint32 {
+	switch {
+	case config.Relocatable:
+		return 0
+	case name == "text":
+		return config.Text
+	case name == "data":
+		return config.Data
+	}
+	if base, ok := config.Sections[name]; ok {
+		return base
+	}
+	return alignUp(follow, 4)
+}
+
+func sectionEnd(sec SectionImage) uint64 {
+	return uint64(sec.Start) + uint64(len(sec.Data))
+}
+
+// Memory is one memory the sections are placed in, Full is empty if there is no image
+type Memory struct {
+	Name string // "" for the unified memory, text for the code sections and data for the others
+	Full Segment
+	Bin  []uint8
+}
+
+// Holds tells whether the section is placed in the memory
+func (this Memory) Holds(sec SectionImage) bool {
+	return this.Name == "" || (this.Name == "text") == isCodeSection(sec.Flags)
+}
+
+// the memories of the layout: one of size from 0, or the ones of the code and the data
+// from their bases in Harvard mode, of CodeSize and DataSize or size if they are 0
+func layoutMemories(config AssembleConfig, size uint32) []Memory {
+	if !config.Harvard {
+		return []Memory{{"", Segment{0, size}, nil}}
+	}
+	codeSize, dataSize := config.CodeSize, config.DataSize
+	if codeSize == 0 {
+		codeSize = size
+	}
+	if dataSize == 0 {
+		dataSize = size
+	}
+	return []Memory{
+		{"text", Segment{config.Text, config.Text + codeSize}, nil},
+		{"data", Segment{config.Data, config.Data + dataSize}, nil},
+	}
+}
+
+// checkLayout reports the sections overlapping each other in a memory, or out of its image
+// which only has the start if there is no size, the empty sections never overlap
+func checkLayout(images []SectionImage, memories []Memory, report func(sec SectionImage, format string, args ...interface{})) {
+	for _, mem := range memories {
+		held := make([]SectionImage, 0, len(images))
+		for _, a := range images {
+			if len(a.Data) == 0 || !mem.Holds(a) {
+				continue
+			}
+			if a.Start < mem.Full.Start || (mem.Full.End > mem.Full.Start && sectionEnd(a) > uint64(mem.Full.End)) {
+				report(a, "Section .%s [0x%08x, 0x%08x) is out of the image [0x%08x, 0x%08x)", a.Name, a.Start, sectionEnd(a), mem.Full.Start, mem.Full.End)
+			}
+			for _, b := range held {
+				if uint64(a.Start) < sectionEnd(b) && uint64(b.Start) < sectionEnd(a) {
+					report(a, "Section .%s [0x%08x, 0x%08x) overlaps .%s [0x%08x, 0x%08x)", a.Name, a.Start, sectionEnd(a), b.Name, b.Start, sectionEnd(b))
+				}
+			}
+			held = append(held, a)
+		}
+	}
+}
+
+// fill the images of the memories with the sections laid out by checkLayout,
+// the ones of Harvard mode without a size take up to the end of the last section
+func buildImages(images []SectionImage, memories []Memory) {
+	for i, mem := range memories {
+		if mem.Name != "" && mem.Full.End == mem.Full.Start {
+			for _, sec := range images {
+				if len(sec.Data) > 0 && mem.Holds(sec) && sectionEnd(sec) > uint64(memories[i].Full.End) {
+					memories[i].Full.End = uint32(sectionEnd(sec))
+				}
+			}
+			mem = memories[i]
+		}
+		memories[i].Bin = make([]uint8, mem.Full.End-mem.Full.Start)
+		for _, sec := range images {
+			if len(sec.Data) > 0 && mem.Holds(sec) {
+				copy(memories[i].Bin[sec.Start-mem.Full.Start:], sec.Data)
+			}
+		}
+	}
+}
+
+// SectionUsage is the space a section takes in the memory
+type SectionUsage struct {
+	Name   string
+	Memory string // the name of the Memory
+	Start  uint32
+	Used   uint32
+	Free   uint32 // up to the next section, or the end of the image for the last one
+}
+
+// Usage gives the space taken by the sections in the order of addresses in each memory
+func (this AssembleResult) Usage() []SectionUsage {
+	memories := this.Memories
+	if len(memories) == 0 {
+		memories = []Memory{{"", this.Full, nil}}
+	}
+	result := make([]SectionUsage, 0, len(this.Sections))
+	for _, mem := range memories {
+		usage := make([]SectionUsage, 0, len(this.Sections))
+		for _, sec := range this.Sections {
+			if mem.Holds(sec) {
+				usage = append(usage, SectionUsage{sec.Name, mem.Name, sec.Start, uint32(len(sec.Data)), 0})
+			}
+		}
+		sort.SliceStable(usage, func(i, j int) bool { return usage[i].Start < usage[j].Start })
+		for i := range usage {
+			end, limit := uint64(usage[i].Start)+uint64(usage[i].Used), uint64(mem.Full.End)
+			if i+1 < len(usage) {
+				limit = uint64(usage[i+1].Start)
+			}
+			if limit > end {
+				usage[i].Free = uint32(limit - end)
+			}
+		}
+		result = append(result, usage...)
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package assembler
+
+import (
+	"sort"
+)
+
+const (
+	SYMBOL_LABEL    = "label"
+	SYMBOL_CONSTANT = "constant"
+	SYMBOL_EXTERN   = "extern" // undefined in a relocatable object
+)
+
+// Symbol is an entry of the final symbol table
+type Symbol struct {
+	Name    string `json:"name"`
+	Address uint32 `json:"address"`
+	Segment string `json:"segment"` // the section, abs for constants
+	Size    uint32 `json:"size"`    // up to the next symbol in the segment, 0 for constants
+	Kind    string `json:"kind"`
+	Global  bool   `json:"global"` // by .globl, or an extern
+}
+
+// the labels of one section, sized by the following ones
+func segmentSymbols(labels map[string]uint32, segment string, end uint32) []Symbol {
+	result := make([]Symbol, 0, len(labels))
+	for name, addr := range labels {
+		result = append(result, Symbol{name, addr, segment, 0, SYMBOL_LABEL, false})
+	}
+	sortSymbols(result)
+	for i := range result {
+		next := end
+		for j := i + 1; j < len(result); j++ {
+			if result[j].Address > result[i].Address {
+				next = result[j].Address
+				break
+			}
+		}
+		if next > result[i].Address {
+			result[i].Size = next - result[i].Address
+		}
+	}
+	return result
+}
+
+func sortSymbols(symbols []Symbol) {
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Address != symbols[j].Address {
+			return symbols[i].Address < symbols[j].Address
+		}
+		return symbols[i].Name < symbols[j].Name
+	})
+}
+
+// buildSymbols makes the symbol table of the labels of the sections and the constants, in the order of addresses
+func buildSymbols(sections []*section, constants *constTable, link *linkage, lookup SymbolLookup) []Symbol {
+	result := make([]Symbol, 0)
+	labels := make(map[string]bool)
+	for _, sec := range sections {
+		result = append(result, segmentSymbols(sec.labels, sec.name, sec.end)...)
+		for name := range sec.labels {
+			labels[name] = true
+		}
+	}
+	for _, name := range constants.names {
+		if labels[name] {
+			continue
+		}
+		func() {
+			defer func() { recover() }() // reported by check
+			if val, err := lookup(name); err == nil {
+				result = append(result, Symbol{name, uint32(val), "abs", 0, SYMBOL_CONSTANT, false})
+			}
+		}()
+	}
+	for i := range result {
+		_, result[i].Global = link.globals[result[i].Name]
+	}
+	for _, name := range link.names {
+		if !labels[name] {
+			result = append(result, Symbol{name, 0, "", 0, SYMBOL_EXTERN, true})
+		}
+	}
+	sortSymbols(result)
+	return result
+}
@@ -1,8 +1,7 @@
 package assembler
 
 import (
-	"errors"
-	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
@@ -28,6 +27,8 @@ type InstructionSyntax struct {
 	args   []Token
 }
 
+var offsetRegex = regexp.MustCompile(`^([\s\S]*)\([\s]*(\$[\w]+)[\s]*\)$`)
+
 const regNames = "atv0v1a0a1a2a3t0t1t2t3t4t5t6t7s0s1s2s3s4s5s6s7t8t9k0k1gpspfpra"
 
 func getRegisterToken(val string) Token {
@@ -43,44 +44,66 @@ func getRegisterToken(val string) Token {
 		if len(name)==2 && ind != -1 && ind % 2 == 0{
 			id = uint32(ind/2 + 1)
 		} else {
-			panic(errors.New(fmt.Sprintf("No this register: %s", name)))
+			panic(errorAt(val, "No this register: %s", val))
 		}
 	}
 	return Token{TC_REG, id, val}
 }
 
+// the value of an operand, which is a signed or an unsigned 32-bit integer
+func checkImmRange(str string, val int64) uint32 {
+	if val < -(int64(1)<<31) || val >= int64(1)<<32 {
+		panic(errorAt(str, "Value %s out of the 32-bit range", str))
+	}
+	return uint32(val)
+}
+
+// the symbol of a TC_SYMBOL token is an expression, which is evaluated by the resolver
 func getImmOrSymToken(val string) Token {
-	to, err := strconv.ParseInt(val, 0, 32)
-	if err == nil {
-		id := uint32(to)
-		return Token{TC_IMM, id, val}
-	} else if len(val) == 0 {
+	if len(val) == 0 {
 		return Token{TC_IMM, 0, val}
+	} else if isConstExpr(val) {
+		to, _ := evalExpr(val, nil)
+		return Token{TC_IMM, checkImmRange(val, to), val}
 	} else {
 		return Token{TC_SYMBOL, 0, val}
 	}
 }
 
+// resolve the symbol tokens by the lookup, the undefined ones are kept if allowUndefined
+func resolveTokens(args []Token, lookup SymbolLookup, allowUndefined bool) []Token {
+	for i, item := range args {
+		if item.class == TC_SYMBOL {
+			val, err := evalExpr(item.symbol, lookup)
+			if err == nil {
+				args[i] = Token{TC_IMM, checkImmRange(item.symbol, val), item.symbol}
+			} else if !(allowUndefined && isUndefined(err)) {
+				panic(err)
+			}
+		}
+	}
+	return args
+}
+
 func getTextTokens(content string) InstructionSyntax {
 	content = trimLine(content)
 	if len(content) == 0 {
 		return InstructionSyntax{"", make([]Token, 0)}
 	}
-	indexSpace := strings.Index(content, " ")
+	indexSpace := strings.IndexAny(content, " \t")
 	if indexSpace == -1 {
-		return InstructionSyntax{content, make([]Token, 0)}
+		return InstructionSyntax{strings.ToLower(content), make([]Token, 0)}
 	}
 	symbol, rem := content[0:indexSpace], content[indexSpace+1:]
-	args := strings.Split(rem, ",")
+	args := splitArgs(rem)
 	tokens := make([]Token, 0)
 	for _, val := range args {
 		val = strings.Trim(val, " \t")
 		if strings.HasPrefix(val, "$") { // $r
 			tokens = append(tokens, getRegisterToken(val))
-		} else if strings.HasSuffix(val, ")") { // offset($r)
-			as := strings.Split(val[0:len(val)-1], "(")
-			tokens = append(tokens, getRegisterToken(as[1]))
-			tokens = append(tokens, getImmOrSymToken(as[0]))
+		} else if match := offsetRegex.FindStringSubmatch(val); match != nil { // offset($r)
+			tokens = append(tokens, getRegisterToken(match[2]))
+			tokens = append(tokens, getImmOrSymToken(strings.Trim(match[1], " \t")))
 		} else { // Imm
 			tokens = append(tokens, getImmOrSymToken(val))
 		}
@@ -97,7 +120,7 @@ func assertRRI(args []Token) bool {
 }
 
 func assertRRRn(args []Token) bool {
-	return len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG && args[2].class == TC_REG
+	return len(args) == 3 && args[0].class == TC_REG && args[1].class == TC_REG && args[2].class != TC_REG
 }
 
 func assertRI(args []Token) bool {
@@ -124,68 +147,240 @@ func assertRn(args []Token) bool {
 	return len(args) == 1 && args[0].class != TC_REG
 }
 
+func assertRRII(args []Token) bool {
+	return len(args) == 4 && args[0].class == TC_REG && args[1].class == TC_REG && args[2].class == TC_IMM && args[3].class == TC_IMM
+}
+
+func assertIRI(args []Token) bool {
+	return len(args) == 3 && args[0].class == TC_IMM && args[1].class == TC_REG && args[2].class == TC_IMM
+}
+
+func assertII(args []Token) bool {
+	return len(args) == 2 && args[0].class == TC_IMM && args[1].class == TC_IMM
+}
+
 type SymbolResolver func(args []Token) []Token
 
-func TextPreprocess(content []string, resolver SymbolResolver, config AssembleConfig) ([]InstructionSyntax, map[string]uint32, bool) {
-	currentAddr := uint32(config.Text)
-	syntaxs := make([]InstructionSyntax, 0, len(content))
-	symbolTable := make(map[string]uint32)
-	flg := true
-	for _, str := range content {
-		if strings.HasSuffix(str, ":") {
-			name := str[0 : len(str)-1]
+func fitsInt16(val uint32) bool {
+	return int32(val) >= -0x8000 && int32(val) <= 0x7fff
+}
+
+// a %lo operand is the 16-bit field itself, which the signed and the zero extended immediates both take
+func isLoField(token Token) bool {
+	str := strings.Trim(token.symbol, " \t")
+	if !strings.HasPrefix(str, "%lo(") || !strings.HasSuffix(str, ")") {
+		return false
+	}
+	depth := 0
+	for i := 3; i < len(str); i++ {
+		if str[i] == '(' {
+			depth++
+		} else if str[i] == ')' {
+			depth--
+			if depth == 0 && i != len(str)-1 { // like %lo(a)+1
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// the immediate fits the signed 16-bit field of the instruction
+func fitsField16(token Token) bool {
+	return token.class == TC_IMM && (fitsInt16(token.value) || isLoField(token))
+}
+
+// the immediate field, either signed or unsigned 16 bits
+func imm16(token Token) uint16 {
+	if int32(token.value) < -0x8000 || int32(token.value) > 0xffff {
+		panic(errorAt(token.symbol, "Immediate %d is out of 16-bit range", int32(token.value)))
+	}
+	return uint16(token.value)
+}
+
+func shamt(token Token) uint8 {
+	if token.value > 31 {
+		panic(errorAt(token.symbol, "Shift amount %d is out of range 0-31", int32(token.value)))
+	}
+	return uint8(token.value)
+}
+
+// a 5-bit field other than the registers, like the hint of pref
+func field5(token Token, name string) uint8 {
+	if token.value > 31 {
+		panic(errorAt(token.symbol, "%s %d is out of range 0-31", name, int32(token.value)))
+	}
+	return uint8(token.value)
+}
+
+// the position and the size of the bit field of ext and ins
+func bitField(pos Token, size Token) (uint8, uint8) {
+	if pos.value > 31 {
+		panic(errorAt(pos.symbol, "Bit position %d is out of range 0-31", int32(pos.value)))
+	}
+	if size.value < 1 || size.value > 32-pos.value {
+		panic(errorAt(size.symbol, "Bit field size %d is out of range 1-%d", int32(size.value), 32-pos.value))
+	}
+	return uint8(pos.value), uint8(size.value)
+}
+
+// the code of the trap instructions
+func trapCode(token Token) uint32 {
+	if token.value > 0x3ff {
+		panic(errorAt(token.symbol, "Trap code %d is out of range 0-1023", int32(token.value)))
+	}
+	return token.value
+}
+
+// the offset field of a branch in the delay slot at nextPC
+func branchOffset(target Token, nextPC uint32) uint16 {
+	if target.value&3 != 0 {
+		panic(errorAt(target.symbol, "Branch target 0x%08x is not word aligned", target.value))
+	}
+	if !branchInRange(target.value, nextPC) {
+		panic(errorAt(target.symbol, "Branch target 0x%08x is out of range from 0x%08x", target.value, nextPC-4))
+	}
+	return uint16((target.value - nextPC) >> 2)
+}
+
+// the target of j/jal, which must be in the 256MB region of the delay slot
+func jumpTarget(target Token, nextPC uint32) uint32 {
+	if target.value&3 != 0 {
+		panic(errorAt(target.symbol, "Jump target 0x%08x is not word aligned", target.value))
+	}
+	if !jumpInRange(target.value, nextPC) {
+		panic(errorAt(target.symbol, "Jump target 0x%08x is out of the 256MB region of 0x%08x", target.value, nextPC-4))
+	}
+	return target.value
+}
+
+// the %hi part of the address token, adjusted for the sign extended %lo part
+func hiToken(token Token) Token {
+	if token.class == TC_IMM {
+		return Token{TC_IMM, ((token.value + 0x8000) >> 16) & 0xffff, token.symbol}
+	}
+	return Token{TC_SYMBOL, 0, "%hi(" + token.symbol + ")"}
+}
+
+func loToken(token Token) Token {
+	if token.class == TC_IMM {
+		return Token{TC_IMM, token.value & 0xffff, token.symbol}
+	}
+	return Token{TC_SYMBOL, 0, "%lo(" + token.symbol + ")"}
+}
+
+// start is the address of the section, defined is the symbols defined out of the section, relax gives
+// the branches to relax and keys returns where each instruction comes from for the next relaxation pass,
+// the delay slots are filled in the reorder mode, which .set noreorder turns off, config gives the byte order and the load delay
+func TextPreprocess(content []SourceLine, resolver SymbolResolver, start uint32, defined map[string]uint32, relax map[relaxKey]int, config AssembleConfig, diags *Diagnostics) (syntaxs []InstructionSyntax, origins []SourceLine, keys []relaxKey, symbolTable map[string]uint32) {
+	currentAddr := start
+	syntaxs = make([]InstructionSyntax, 0, len(content))
+	origins = make([]SourceLine, 0, len(content))
+	keys = make([]relaxKey, 0, len(content))
+	symbolTable = make(map[string]uint32)
+	reorder := true
+	movable := make([]bool, 0, len(content)) // may be moved into the delay slot after it
+	labelAddr := start - 1                   // of the last label, no branch with a label gets filled
+	for lineIndex, line := range content {
+		if mode, ok := reorderMode(line.Text); ok {
+			reorder = mode
+			continue
+		}
+		labels, str := splitLabels(line.Text)
+		for _, name := range labels {
 			_, exists := symbolTable[name]
-			if exists {
-				flg = false
-				fmt.Printf("Symbol %s has been defined.\n", name)
-				break
+			_, existsOut := defined[name]
+			if exists || existsOut {
+				diags.errorf(line, name, "Symbol %s has been defined.", name)
+				continue
 			}
 			symbolTable[name] = currentAddr
+			labelAddr = currentAddr
+		}
+		if str == "" {
 			continue
-		} else {
+		}
+		diags.guard(line, func() {
 			syntax := getTextTokens(str)
 			syntax.args = resolver(syntax.args)
-			tosyn, ok := textPreprocessOne(syntax)
+			tosyn, ok := textPreprocessOne(syntax, config)
 			if !ok {
-				flg = false
-				fmt.Printf("Preprocessing failed: %s\n", str)
-				break
+				panic(errorAt(syntax.symbol, "Invalid instruction or operands: %s", str))
 			}
-			for _, v := range tosyn {
-				syntaxs = append(syntaxs, v)
+			for i, v := range tosyn {
+				key := relaxKey{lineIndex, i}
+				expanded := []InstructionSyntax{v}
+				if level := relax[key]; level != RELAX_NONE {
+					expanded = relaxBranch(v, level, currentAddr)
+				}
+				for _, item := range expanded {
+					syntaxs = append(syntaxs, item)
+					origins = append(origins, line)
+					keys = append(keys, key)
+					movable = append(movable, reorder && !hasDelaySlot(item) && !(config.LoadDelay && isLoad(item)))
+					currentAddr += 4
+				}
+				branch := expanded[len(expanded)-1]
+				if !reorder || !hasDelaySlot(branch) {
+					continue
+				}
+				// the relaxed ones end with a jump after their own instructions, so they get a nop
+				n := len(syntaxs)
+				if len(expanded) == 1 && n >= 2 && movable[n-2] && labelAddr != currentAddr-4 && canFillSlot(syntaxs[n-2], branch) {
+					syntaxs[n-2], syntaxs[n-1] = syntaxs[n-1], syntaxs[n-2]
+					origins[n-2], origins[n-1] = origins[n-1], origins[n-2]
+					keys[n-2], keys[n-1] = keys[n-1], keys[n-2]
+					movable[n-2], movable[n-1] = false, false
+					continue
+				}
+				syntaxs = append(syntaxs, syn("sll", tokenZERO, tokenZERO, immToken(0)))
+				origins = append(origins, line)
+				keys = append(keys, relaxKey{lineIndex, -1})
+				movable = append(movable, false)
 				currentAddr += 4
 			}
-		}
+		})
 	}
-	return syntaxs, symbolTable, flg
+	return syntaxs, origins, keys, symbolTable
 }
 
-func textPreprocessOne(syntax InstructionSyntax) ([]InstructionSyntax, bool) {
-	tokenZERO := Token{class: TC_REG, value: uint32(instruction.GPR_ZERO)}
-	tokenAT := Token{class: TC_REG, value: uint32(instruction.GPR_AT)}
-
+func textPreprocessOne(syntax InstructionSyntax, config AssembleConfig) ([]InstructionSyntax, bool) {
 	switch syntax.symbol {
 	case "li":
 		if !assertRRn(syntax.args) {
 			break
 		}
 		imm := syntax.args[1].value
-		if imm <= 0xffff { // dont have high 16 bit
-			if (imm & 0x8000) > 0 { // sign bit is 1
-				return []InstructionSyntax{
-					InstructionSyntax{"ori", []Token{
-						Token{class: TC_REG, value: uint32(syntax.args[0].value)},
-						tokenAT,
-						Token{class: TC_IMM, value: imm}}},
-				}, true
-			} else {
-				return []InstructionSyntax{
-					InstructionSyntax{"addiu", []Token{
-						Token{class: TC_REG, value: uint32(syntax.args[0].value)},
-						tokenZERO,
-						Token{class: TC_IMM, value: imm}}},
-				}, true
-			}
+		if syntax.args[1].class == TC_SYMBOL { // not known yet, load all 32 bits
+			return []InstructionSyntax{
+				InstructionSyntax{"lui", []Token{
+					tokenAT,
+					hiToken(syntax.args[1])}},
+				InstructionSyntax{"addiu", []Token{
+					syntax.args[0],
+					tokenAT,
+					loToken(syntax.args[1])}},
+			}, true
+		} else if fitsInt16(imm) { // sign extended by addiu
+			return []InstructionSyntax{
+				InstructionSyntax{"addiu", []Token{
+					Token{class: TC_REG, value: uint32(syntax.args[0].value)},
+					tokenZERO,
+					Token{class: TC_IMM, value: imm & 0xffff}}},
+			}, true
+		} else if imm <= 0xffff { // zero extended by ori
+			return []InstructionSyntax{
+				InstructionSyntax{"ori", []Token{
+					Token{class: TC_REG, value: uint32(syntax.args[0].value)},
+					tokenZERO,
+					Token{class: TC_IMM, value: imm}}},
+			}, true
+		} else if imm&0xffff == 0 { // only high 16 bit
+			return []InstructionSyntax{
+				InstructionSyntax{"lui", []Token{
+					Token{class: TC_REG, value: uint32(syntax.args[0].value)},
+					Token{class: TC_IMM, value: imm >> 16}}},
+			}, true
 		} else {
 			return []InstructionSyntax{
 				InstructionSyntax{"lui", []Token{
@@ -198,23 +393,37 @@ func textPreprocessOne(syntax InstructionSyntax) ([]InstructionSyntax, bool) {
 			}, true
 		}
 	case "la":
-		if assertRRRn(syntax.args) {
+		if assertRRRn(syntax.args) { // la $r, offset($base)
+			if fitsField16(syntax.args[2]) {
+				return []InstructionSyntax{
+					InstructionSyntax{"addiu", []Token{
+						syntax.args[0],
+						syntax.args[1],
+						syntax.args[2]}},
+				}, true
+			}
 			return []InstructionSyntax{
-				InstructionSyntax{"ori", []Token{
+				InstructionSyntax{"lui", []Token{
 					tokenAT,
-					tokenZERO,
-					syntax.args[2]}},
-				InstructionSyntax{"add", []Token{
+					hiToken(syntax.args[2])}},
+				InstructionSyntax{"addiu", []Token{
+					tokenAT,
+					tokenAT,
+					loToken(syntax.args[2])}},
+				InstructionSyntax{"addu", []Token{
 					syntax.args[0],
-					syntax.args[1],
-					tokenAT}},
+					tokenAT,
+					syntax.args[1]}},
 			}, true
-		} else if assertRRn(syntax.args) {
+		} else if assertRRn(syntax.args) { // la $r, address
 			return []InstructionSyntax{
-				InstructionSyntax{"addi", []Token{
+				InstructionSyntax{"lui", []Token{
+					tokenAT,
+					hiToken(syntax.args[1])}},
+				InstructionSyntax{"addiu", []Token{
 					syntax.args[0],
-					tokenZERO,
-					syntax.args[1]}},
+					tokenAT,
+					loToken(syntax.args[1])}},
 			}, true
 		}
 	case "neg":
@@ -386,7 +595,7 @@ func textPreprocessOne(syntax InstructionSyntax) ([]InstructionSyntax, bool) {
 	case "":
 		break
 	default:
-		return []InstructionSyntax{syntax}, true
+		return textPreprocessLibrary(syntax, config)
 
 	}
 	return []InstructionSyntax{syntax}, false
@@ -409,12 +618,12 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Addi(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+		return instruction.Addi(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 	case "addiu":
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Addiu(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+		return instruction.Addiu(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 	case "sub":
 		if !assertRRR(args) {
 			break
@@ -479,7 +688,7 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Andi(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+		return instruction.Andi(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 	case "or":
 		if !assertRRR(args) {
 			break
@@ -489,7 +698,7 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Ori(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+		return instruction.Ori(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 	case "nor":
 		if !assertRRR(args) {
 			break
@@ -504,7 +713,7 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Xori(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+		return instruction.Xori(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 	case "slt":
 		if !assertRRR(args) {
 			break
@@ -514,7 +723,7 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Slti(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+		return instruction.Slti(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 	case "sltu":
 		if !assertRRR(args) {
 			break
@@ -524,22 +733,22 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Sltiu(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+		return instruction.Sltiu(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 	case "sll":
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Sll(uint8(args[0].value), uint8(args[1].value), uint8(args[2].value)), true
+		return instruction.Sll(uint8(args[0].value), uint8(args[1].value), shamt(args[2])), true
 	case "sra":
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Sra(uint8(args[0].value), uint8(args[1].value), uint8(args[2].value)), true
+		return instruction.Sra(uint8(args[0].value), uint8(args[1].value), shamt(args[2])), true
 	case "srl":
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Srl(uint8(args[0].value), uint8(args[1].value), uint8(args[2].value)), true
+		return instruction.Srl(uint8(args[0].value), uint8(args[1].value), shamt(args[2])), true
 	case "sllv":
 		if !assertRRR(args) {
 			break
@@ -559,52 +768,92 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Beq(uint8(args[0].value), uint8(args[1].value), uint16((args[2].value-nextPC)>>2)), true
+		return instruction.Beq(uint8(args[0].value), uint8(args[1].value), branchOffset(args[2], nextPC)), true
 	case "bne":
 		if !assertRRI(args) {
 			break
 		}
-		return instruction.Bne(uint8(args[0].value), uint8(args[1].value), uint16((args[2].value-nextPC)>>2)), true
+		return instruction.Bne(uint8(args[0].value), uint8(args[1].value), branchOffset(args[2], nextPC)), true
 	case "bgez":
 		if !assertRI(args) {
 			break
 		}
-		return instruction.Bgez(uint8(args[0].value), uint16((args[1].value-nextPC)>>2)), true
+		return instruction.Bgez(uint8(args[0].value), branchOffset(args[1], nextPC)), true
 	case "bgezal":
 		if !assertRI(args) {
 			break
 		}
-		return instruction.Bgezal(uint8(args[0].value), uint16((args[1].value-nextPC)>>2)), true
+		return instruction.Bgezal(uint8(args[0].value), branchOffset(args[1], nextPC)), true
 	case "bgtz":
 		if !assertRI(args) {
 			break
 		}
-		return instruction.Bgtz(uint8(args[0].value), uint16((args[1].value-nextPC)>>2)), true
+		return instruction.Bgtz(uint8(args[0].value), branchOffset(args[1], nextPC)), true
 	case "blez":
 		if !assertRI(args) {
 			break
 		}
-		return instruction.Blez(uint8(args[0].value), uint16((args[1].value-nextPC)>>2)), true
+		return instruction.Blez(uint8(args[0].value), branchOffset(args[1], nextPC)), true
 	case "bltz":
 		if !assertRI(args) {
 			break
 		}
-		return instruction.Bltz(uint8(args[0].value), uint16((args[1].value-nextPC)>>2)), true
+		return instruction.Bltz(uint8(args[0].value), branchOffset(args[1], nextPC)), true
 	case "bltzal":
 		if !assertRI(args) {
 			break
 		}
-		return instruction.Bltzal(uint8(args[0].value), uint16((args[1].value-nextPC)>>2)), true
+		return instruction.Bltzal(uint8(args[0].value), branchOffset(args[1], nextPC)), true
+	case "beql":
+		if !assertRRI(args) {
+			break
+		}
+		return instruction.Beql(uint8(args[0].value), uint8(args[1].value), branchOffset(args[2], nextPC)), true
+	case "bnel":
+		if !assertRRI(args) {
+			break
+		}
+		return instruction.Bnel(uint8(args[0].value), uint8(args[1].value), branchOffset(args[2], nextPC)), true
+	case "bgezl":
+		if !assertRI(args) {
+			break
+		}
+		return instruction.Bgezl(uint8(args[0].value), branchOffset(args[1], nextPC)), true
+	case "bgezall":
+		if !assertRI(args) {
+			break
+		}
+		return instruction.Bgezall(uint8(args[0].value), branchOffset(args[1], nextPC)), true
+	case "bgtzl":
+		if !assertRI(args) {
+			break
+		}
+		return instruction.Bgtzl(uint8(args[0].value), branchOffset(args[1], nextPC)), true
+	case "blezl":
+		if !assertRI(args) {
+			break
+		}
+		return instruction.Blezl(uint8(args[0].value), branchOffset(args[1], nextPC)), true
+	case "bltzl":
+		if !assertRI(args) {
+			break
+		}
+		return instruction.Bltzl(uint8(args[0].value), branchOffset(args[1], nextPC)), true
+	case "bltzall":
+		if !assertRI(args) {
+			break
+		}
+		return instruction.Bltzall(uint8(args[0].value), branchOffset(args[1], nextPC)), true
 	case "j":
 		if !assertI(args) {
 			break
 		}
-		return instruction.J(uint32(args[0].value)), true
+		return instruction.J(jumpTarget(args[0], nextPC)), true
 	case "jal":
 		if !assertI(args) {
 			break
 		}
-		return instruction.Jal(uint32(args[0].value)), true
+		return instruction.Jal(jumpTarget(args[0], nextPC)), true
 	case "jr":
 		if !assertR(args) {
 			break
@@ -620,54 +869,211 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 		if !assertRI(args) {
 			break
 		}
-		return instruction.Lui(uint8(args[0].value), uint16(args[1].value)), true
+		return instruction.Lui(uint8(args[0].value), imm16(args[1])), true
 	case "lb":
 		if assertRRI(args) {
-			return instruction.Lb(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Lb(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Lb(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Lb(uint8(args[0].value), uint8(0), imm16(args[1])), true
 		}
 	case "lbu":
 		if assertRRI(args) {
-			return instruction.Lbu(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Lbu(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Lbu(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Lbu(uint8(args[0].value), uint8(0), imm16(args[1])), true
 		}
 	case "lh":
 		if assertRRI(args) {
-			return instruction.Lh(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Lh(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Lh(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Lh(uint8(args[0].value), uint8(0), imm16(args[1])), true
 		}
 	case "lhu":
 		if assertRRI(args) {
-			return instruction.Lhu(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Lhu(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Lhu(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Lhu(uint8(args[0].value), uint8(0), imm16(args[1])), true
 		}
 	case "sb":
 		if assertRRI(args) {
-			return instruction.Sb(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Sb(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Sb(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Sb(uint8(args[0].value), uint8(0), imm16(args[1])), true
 		}
 	case "lw":
 		if assertRRI(args) {
-			return instruction.Lw(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Lw(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Lw(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Lw(uint8(args[0].value), uint8(0), imm16(args[1])), true
 		}
 	case "sw":
 		if assertRRI(args) {
-			return instruction.Sw(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Sw(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Sw(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Sw(uint8(args[0].value), uint8(0), imm16(args[1])), true
 		}
 	case "sh":
 		if assertRRI(args) {
-			return instruction.Sh(uint8(args[0].value), uint8(args[1].value), uint16(args[2].value)), true
+			return instruction.Sh(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
+		} else if assertRI(args) {
+			return instruction.Sh(uint8(args[0].value), uint8(0), imm16(args[1])), true
+		}
+	case "lwl":
+		if assertRRI(args) {
+			return instruction.Lwl(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
+		} else if assertRI(args) {
+			return instruction.Lwl(uint8(args[0].value), uint8(0), imm16(args[1])), true
+		}
+	case "lwr":
+		if assertRRI(args) {
+			return instruction.Lwr(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
 		} else if assertRI(args) {
-			return instruction.Sh(uint8(args[0].value), uint8(0), uint16(args[1].value)), true
+			return instruction.Lwr(uint8(args[0].value), uint8(0), imm16(args[1])), true
+		}
+	case "swl":
+		if assertRRI(args) {
+			return instruction.Swl(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
+		} else if assertRI(args) {
+			return instruction.Swl(uint8(args[0].value), uint8(0), imm16(args[1])), true
+		}
+	case "swr":
+		if assertRRI(args) {
+			return instruction.Swr(uint8(args[0].value), uint8(args[1].value), imm16(args[2])), true
+		} else if assertRI(args) {
+			return instruction.Swr(uint8(args[0].value), uint8(0), imm16(args[1])), true
+		}
+	case "pref":
+		if assertIRI(args) {
+			return instruction.Pref(field5(args[0], "Hint"), uint8(args[1].value), imm16(args[2])), true
+		} else if assertII(args) {
+			return instruction.Pref(field5(args[0], "Hint"), uint8(0), imm16(args[1])), true
+		}
+	case "cache":
+		if assertIRI(args) {
+			return instruction.Cache(field5(args[0], "Cache operation"), uint8(args[1].value), imm16(args[2])), true
+		} else if assertII(args) {
+			return instruction.Cache(field5(args[0], "Cache operation"), uint8(0), imm16(args[1])), true
+		}
+	case "movn":
+		if !assertRRR(args) {
+			break
+		}
+		return instruction.Movn(uint8(args[0].value), uint8(args[1].value), uint8(args[2].value)), true
+	case "movz":
+		if !assertRRR(args) {
+			break
+		}
+		return instruction.Movz(uint8(args[0].value), uint8(args[1].value), uint8(args[2].value)), true
+	case "clo":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Clo(uint8(args[0].value), uint8(args[1].value)), true
+	case "clz":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Clz(uint8(args[0].value), uint8(args[1].value)), true
+	case "madd":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Madd(uint8(args[0].value), uint8(args[1].value)), true
+	case "maddu":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Maddu(uint8(args[0].value), uint8(args[1].value)), true
+	case "msub":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Msub(uint8(args[0].value), uint8(args[1].value)), true
+	case "msubu":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Msubu(uint8(args[0].value), uint8(args[1].value)), true
+	case "teq", "tne", "tge", "tgeu", "tlt", "tltu":
+		code := uint32(0)
+		if assertRRI(args) {
+			code = trapCode(args[2])
+		} else if !assertRR(args) {
+			break
+		}
+		create := map[string]func(uint8, uint8, uint32) instruction.RInstruction{
+			"teq": instruction.Teq, "tne": instruction.Tne, "tge": instruction.Tge,
+			"tgeu": instruction.Tgeu, "tlt": instruction.Tlt, "tltu": instruction.Tltu,
+		}[syntax.symbol]
+		return create(uint8(args[0].value), uint8(args[1].value), code), true
+	case "teqi", "tnei", "tgei", "tgeiu", "tlti", "tltiu":
+		if !assertRI(args) {
+			break
+		}
+		create := map[string]func(uint8, uint16) instruction.IInstruction{
+			"teqi": instruction.Teqi, "tnei": instruction.Tnei, "tgei": instruction.Tgei,
+			"tgeiu": instruction.Tgeiu, "tlti": instruction.Tlti, "tltiu": instruction.Tltiu,
+		}[syntax.symbol]
+		return create(uint8(args[0].value), imm16(args[1])), true
+	case "rotr":
+		if !assertRRI(args) {
+			break
+		}
+		return instruction.Rotr(uint8(args[0].value), uint8(args[1].value), shamt(args[2])), true
+	case "rotrv":
+		if !assertRRR(args) {
+			break
+		}
+		return instruction.Rotrv(uint8(args[0].value), uint8(args[1].value), uint8(args[2].value)), true
+	case "ext":
+		if !assertRRII(args) {
+			break
+		}
+		pos, size := bitField(args[2], args[3])
+		return instruction.Ext(uint8(args[0].value), uint8(args[1].value), pos, size), true
+	case "ins":
+		if !assertRRII(args) {
+			break
+		}
+		pos, size := bitField(args[2], args[3])
+		return instruction.Ins(uint8(args[0].value), uint8(args[1].value), pos, size), true
+	case "seb":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Seb(uint8(args[0].value), uint8(args[1].value)), true
+	case "seh":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Seh(uint8(args[0].value), uint8(args[1].value)), true
+	case "wsbh":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Wsbh(uint8(args[0].value), uint8(args[1].value)), true
+	case "rdhwr":
+		if !assertRR(args) {
+			break
+		}
+		return instruction.Rdhwr(uint8(args[0].value), uint8(args[1].value)), true
+	case "di":
+		if len(args) == 0 {
+			return instruction.Di(0), true
+		} else if assertR(args) {
+			return instruction.Di(uint8(args[0].value)), true
+		}
+	case "ei":
+		if len(args) == 0 {
+			return instruction.Ei(0), true
+		} else if assertR(args) {
+			return instruction.Ei(uint8(args[0].value)), true
+		}
+	case "sync":
+		if len(args) == 0 {
+			return instruction.Sync(0), true
+		} else if assertI(args) {
+			return instruction.Sync(field5(args[0], "Sync type")), true
 		}
 	case "syscall":
 		if len(args) > 0 {
@@ -684,58 +1090,77 @@ func textParseOne(syntax InstructionSyntax, resolver SymbolResolver, nextPC uint
 	return nil, false
 }
 
-func buildText(content []string, config AssembleConfig, symbolTable map[string]uint32) []instruction.Instruction {
-	result := make([]instruction.Instruction, 0)
-
-	symbolResWithoutError := func(args []Token) []Token {
-		for i, item := range args {
-			if item.class == TC_SYMBOL {
-				val, ok := symbolTable[item.symbol]
-				if ok {
-					args[i] = Token{TC_IMM, val, item.symbol}
-				} else {
+// the instructions of a code section laid out, before they are encoded
+type textLayout struct {
+	syntaxs []InstructionSyntax
+	origins []SourceLine
+	keys    []relaxKey
+}
 
-				}
-			}
+// layoutText expands the pseudo-instructions of the code section and places its labels,
+// which are added to symbolTable, the relocatable objects keep all the full forms
+func layoutText(sec *section, config AssembleConfig, symbolTable map[string]uint32, constants *constTable, relax map[relaxKey]int, diags *Diagnostics) {
+	// only the symbols out of the section are known while preprocessing,
+	// and none of the labels for relocatable objects, so all of them take the full forms
+	known := make(map[string]uint32)
+	for k, v := range symbolTable {
+		if !config.Relocatable {
+			known[k] = v
 		}
-		return args
+	}
+	symbolResWithoutError := func(args []Token) []Token {
+		return resolveTokens(args, constants.lookup(known), true)
 	}
 
-	syntaxs, textSymbols, ok := TextPreprocess(content, symbolResWithoutError, config)
-
-	if ok {
-		for k, v := range textSymbols {
-			_, exists := symbolTable[k]
-			if exists {
-				panic(errors.New(fmt.Sprintf("Symbol %s has been defined.", k)))
-			}
-			symbolTable[k] = v
+	layout := &sec.layout
+	for {
+		pass := make(Diagnostics, 0)
+		layout.syntaxs, layout.origins, layout.keys, sec.labels = TextPreprocess(sec.lines, symbolResWithoutError, sec.start, symbolTable, relax, config, &pass)
+		if !config.Relax || !relaxPass(layout.syntaxs, layout.keys, relax, sec.start, constants.lookup(mergeSymbols(symbolTable, sec.labels))) {
+			*diags = append(*diags, pass...)
+			break
 		}
-	} else {
-		panic(errors.New(fmt.Sprintf("Text prepocessing failed.")))
 	}
+	for k, v := range sec.labels {
+		symbolTable[k] = v
+	}
+	sec.end = sec.start + uint32(len(layout.syntaxs))<<2
+}
+
+// encodeText encodes the instructions laid out when all the labels are known, only the ones
+// of the release of config, reloc records the relocations of a relocatable object, nil for executables
+func encodeText(sec *section, symbolTable map[string]uint32, constants *constTable, reloc *relocator, config AssembleConfig, diags *Diagnostics) {
+	sec.instrs = make([]instruction.Instruction, 0, len(sec.layout.syntaxs))
+	sec.listing = make([]ListingLine, 0, len(sec.lines))
 	symbolRes := func(args []Token) []Token {
-		for i, item := range args {
-			if item.class == TC_SYMBOL {
-				val, ok := symbolTable[item.symbol]
-				if ok {
-					args[i] = Token{TC_IMM, val, item.symbol}
-				} else {
-					fmt.Printf("No this symbol: %s\n", item.symbol)
-				}
-			}
-		}
-		return args
+		return resolveTokens(args, constants.lookup(symbolTable), false)
 	}
 
-	currentAddr := uint32(config.Text)
-	for _, syn := range syntaxs {
-		res, ok := textParseOne(syn, symbolRes, currentAddr+4)
-		if !ok {
-			panic(errors.New(fmt.Sprintf("Parse failed: %s\n", syn.symbol)))
-		}
-		result = append(result, res)
+	origins, keys := sec.layout.origins, sec.layout.keys
+	currentAddr := sec.start
+	for i, syn := range sec.layout.syntaxs {
+		diags.guard(origins[i], func() {
+			if rev := instruction.Revision(syn.symbol); rev > config.revision() {
+				panic(errorAt(syn.symbol, "Instruction %s is in MIPS32 release %d, but the target is release %d", syn.symbol, rev, config.revision()))
+			}
+			if reloc != nil {
+				reloc.relocateText(syn, currentAddr, sec.name)
+			}
+			res, ok := textParseOne(syn, symbolRes, currentAddr+4)
+			if !ok {
+				panic(errorAt(syn.symbol, "Invalid instruction or operands: %s", syn.symbol))
+			}
+			sec.instrs = append(sec.instrs, res)
+			if n := len(sec.listing); n > 0 && i > 0 && keys[i-1].line == keys[i].line {
+				sec.listing[n-1].Instrs = append(sec.listing[n-1].Instrs, res)
+			} else {
+				sec.listing = append(sec.listing, ListingLine{Source: origins[i], Address: currentAddr, Instrs: []instruction.Instruction{res}})
+			}
+		})
 		currentAddr += 4
 	}
-	return result
+	sec.data = make([]uint8, len(sec.instrs)<<2)
+	for i, bits := range instruction.ToBin(sec.instrs) {
+		config.ByteOrder().PutUint32(sec.data[i<<2:], bits)
+	}
 }
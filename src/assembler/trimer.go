@@ -1,48 +1,76 @@
 package assembler
 
 import (
+    "regexp"
     "strings"
 )
 
-const (
-    DEFAULT_SEGMENT = ""
-)
+// SourceLine is one line of source with its origin
+type SourceLine struct {
+    File   string
+    Line   int
+    Column int // column where Text starts in the original line
+    Text   string
+    // the macro invocation the line is expanded from, nil if not in a macro
+    Invocation *SourceLine
+}
 
-func getSegment(str string) (string, bool) {
-    if strings.HasPrefix(str, ".") {
-        return str[1:], true
+func NewSource(file string, content []string) []SourceLine {
+    result := make([]SourceLine, 0, len(content))
+    for i, str := range content {
+        result = append(result, SourceLine{file, i + 1, 1, str, nil})
+    }
+    return result
+}
+
+// index of the comment mark, ignoring marks in string and char literals
+func commentIndex(str string) int {
+    quote := byte(0)
+    for i := 0; i < len(str); i++ {
+        switch chr := str[i]; {
+        case quote != 0 && chr == '\\':
+            i++
+        case quote != 0 && chr == quote:
+            quote = 0
+        case quote == 0 && (chr == '"' || chr == '\''):
+            quote = chr
+        case quote == 0 && chr == '#':
+            return i
+        }
+    }
+    return -1
+}
+
+var labelRegex = regexp.MustCompile(`^([A-Za-z_][\w.]*)[\s]*:[\s]*`)
+
+// splitLabels takes the "label:" prefixes out of the statement
+func splitLabels(str string) ([]string, string) {
+    labels := make([]string, 0)
+    for {
+        match := labelRegex.FindStringSubmatch(str)
+        if match == nil {
+            return labels, str
+        }
+        labels = append(labels, match[1])
+        str = str[len(match[0]):]
     }
-    return str, false
 }
 
 func trimLine(str string) string {
-    ind := strings.Index(str, "#")
+    ind := commentIndex(str)
     if ind != -1 {
         str = str[0:ind]
     }
     return strings.Trim(str, " \t")
 }
 
-func TrimSplitSegment(content []string) map[string][]string {
-    var result map[string][]string = make(map[string][]string)
-    currentSeg := DEFAULT_SEGMENT
-    result[currentSeg] = make([]string, 0)
-    for _, str := range content {
-        str = trimLine(str)
-        if len(str) == 0 {
-            continue
-        }
-
-        if seg, ok := getSegment(str); ok {
-            _, ok := result[seg]
-            if !ok {
-                result[seg] = make([]string, 0)
-            }
-            currentSeg = seg
-            continue
-        }
-        val, _ := result[currentSeg]
-        result[currentSeg] = append(val, str)
+func trimSourceLine(line SourceLine) SourceLine {
+    ind := commentIndex(line.Text)
+    if ind != -1 {
+        line.Text = line.Text[0:ind]
     }
-    return result
+    trimmed := strings.TrimLeft(line.Text, " \t")
+    line.Column += len(line.Text) - len(trimmed)
+    line.Text = strings.TrimRight(trimmed, " \t\r")
+    return line
 }
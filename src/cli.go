@@ -1,17 +1,191 @@
 package main
 
 import (
+	"debug/elf"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	ass "./assembler"
 	dum "./dumper"
 	ins "./instruction"
+	"./memfile"
 	sim "./simulator"
 )
 
-func cliAs(inputFile string, bitsFile string, asmFile string, binFile string, mifFile string, dataSegment uint32, textSegment uint32, fullSize int32) (int, []ins.Instruction, *ass.AssembleResult) {
+type stringList []string
+
+func (this *stringList) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *stringList) Set(val string) error {
+	*this = append(*this, val)
+	return nil
+}
+
+// the base addresses of the sections given by -section name=address
+type sectionBases map[string]uint32
+
+func (this sectionBases) String() string {
+	strs := make([]string, 0, len(this))
+	for name, base := range this {
+		strs = append(strs, fmt.Sprintf("%s=0x%08x", name, base))
+	}
+	return strings.Join(strs, ",")
+}
+
+func (this sectionBases) Set(val string) error {
+	ind := strings.Index(val, "=")
+	if ind == -1 {
+		return fmt.Errorf("expect name=address")
+	}
+	base, err := strconv.ParseUint(val[ind+1:], 0, 32)
+	if err != nil {
+		return err
+	}
+	this[strings.TrimPrefix(val[:ind], ".")] = uint32(base)
+	return nil
+}
+
+// the names of the output files, empty for the ones not needed
+type outputFiles struct {
+	bitsFile, asmFile, binFile, mifFile, lstFile, mapFile, mapJSONFile, elfFile string
+	coeFile, memhFile, membFile, hexFile, srecFile, logisimFile                 string
+	// how the memory initialization files are written, the width is 0 for the default of each format
+	memory memfile.Options
+	// of the Logisim image: 2 for v2.0 raw, 3 for v3.0 hex words addressed
+	logisimVersion int
+}
+
+// a memory initialization file format and the width it takes by default
+type memoryFormat struct {
+	name  string
+	width int
+	write func(image []uint8, opt memfile.Options) ([]string, error)
+}
+
+var (
+	mifFormat  = memoryFormat{"MIF", 8, memfile.ToMIF}
+	coeFormat  = memoryFormat{"COE", 32, memfile.ToCOE}
+	memhFormat = memoryFormat{"$readmemh", 32, func(image []uint8, opt memfile.Options) ([]string, error) { return memfile.ToReadmem(image, opt, 16) }}
+	membFormat = memoryFormat{"$readmemb", 32, func(image []uint8, opt memfile.Options) ([]string, error) { return memfile.ToReadmem(image, opt, 2) }}
+)
+
+func logisimFormat(version int) memoryFormat {
+	return memoryFormat{"Logisim", 32, func(image []uint8, opt memfile.Options) ([]string, error) {
+		return memfile.ToLogisim(image, opt, version)
+	}}
+}
+
+// the names of the image files of the memory of Harvard mode, like output.text.bin for output.bin
+func (this outputFiles) forMemory(name string) outputFiles {
+	rename := func(file string) string {
+		if file == "" {
+			return ""
+		}
+		ext := filepath.Ext(file)
+		return file[:len(file)-len(ext)] + "." + name + ext
+	}
+	this.binFile, this.mifFile, this.coeFile = rename(this.binFile), rename(this.mifFile), rename(this.coeFile)
+	this.memhFile, this.membFile = rename(this.memhFile), rename(this.membFile)
+	this.hexFile, this.srecFile, this.logisimFile = rename(this.hexFile), rename(this.srecFile), rename(this.logisimFile)
+	return this
+}
+
+// the non-empty sections in the memory by their addresses, the record files
+// only have them and not the padding between them
+func recordParts(builded ass.AssembleResult, mem ass.Memory) []memfile.Image {
+	parts := make([]memfile.Image, 0, len(builded.Sections))
+	for _, sec := range builded.Sections {
+		if len(sec.Data) > 0 && mem.Holds(sec) {
+			parts = append(parts, memfile.Image{Base: sec.Start, Data: sec.Data})
+		}
+	}
+	sort.SliceStable(parts, func(i, j int) bool { return parts[i].Base < parts[j].Base })
+	return parts
+}
+
+// write the image as the bin, the memory initialization and the record files given,
+// only the record files have the base address and the entry
+func writeImageFiles(image memfile.Image, files outputFiles) int {
+	if files.binFile != "" {
+		err := writeAllBytes(files.binFile, image.Data)
+		if err != nil {
+			println("Generate bin file failed", err)
+			return -1
+		}
+		println("Bin file:", files.binFile)
+	}
+	for _, item := range []struct {
+		file   string
+		format memoryFormat
+	}{{files.mifFile, mifFormat}, {files.coeFile, coeFormat}, {files.memhFile, memhFormat}, {files.membFile, membFormat}, {files.logisimFile, logisimFormat(files.logisimVersion)}} {
+		if item.file == "" {
+			continue
+		}
+		opt := files.memory
+		if opt.Width == 0 {
+			opt.Width = item.format.width
+		}
+		lines, err := item.format.write(image.Data, opt)
+		if err == nil {
+			err = writeAllLines(item.file, lines)
+		}
+		if err != nil {
+			fmt.Printf("Generate %s file failed: %v\n", item.format.name, err)
+			return -1
+		}
+		fmt.Printf("%s file: %s\n", item.format.name, item.file)
+	}
+	for _, item := range []struct {
+		file  string
+		name  string
+		write func(image memfile.Image) ([]string, error)
+	}{{files.hexFile, "Intel HEX", memfile.ToIntelHex}, {files.srecFile, "S-record", memfile.ToSRecord}} {
+		if item.file == "" {
+			continue
+		}
+		lines, err := item.write(image)
+		if err == nil {
+			err = writeAllLines(item.file, lines)
+		}
+		if err != nil {
+			fmt.Printf("Generate %s file failed: %v\n", item.name, err)
+			return -1
+		}
+		fmt.Printf("%s file: %s\n", item.name, item.file)
+	}
+	return 0
+}
+
+// print the diagnostics, returns whether it succeeded
+func printDiagnostics(diags ass.Diagnostics, err error) bool {
+	if err == nil {
+		println("done")
+		for _, diag := range diags {
+			println(diag.String())
+		}
+		return true
+	}
+	println("failed")
+	if diags, ok := err.(ass.Diagnostics); ok {
+		for _, diag := range diags {
+			println(diag.String())
+		}
+		println(len(diags.Errors()), "error(s)")
+	} else {
+		println(err.Error())
+	}
+	return false
+}
+
+func cliAs(inputFile string, files outputFiles, config ass.AssembleConfig, fullSize int32) (int, []ins.Instruction, *ass.AssembleResult) {
 	if inputFile == "" {
 		fmt.Printf("Please give the input file name")
 		return -1, nil, nil
@@ -22,56 +196,154 @@ func cliAs(inputFile string, bitsFile string, asmFile string, binFile string, mi
 		return -1, nil, nil
 	}
 	print("Assembling...")
-	instrs, builded, err := ass.Assemble(content, ass.AssembleConfig{Data: dataSegment, Text: textSegment}, fullSize)
-	if err == nil {
-		println("done")
-	} else {
-		println("failed")
-		println(err.Error())
+	instrs, builded, err := ass.AssembleSource(ass.NewSource(inputFile, content), config, fullSize)
+	if !printDiagnostics(builded.Diagnostics, err) {
 		return -1, nil, nil
 	}
+	if cliOutput(instrs, builded, files, filepath.Base(inputFile), config.Relocatable) != 0 {
+		return -1, nil, nil
+	}
+	return 0, instrs, &builded
+}
+
+func cliLink(inputFiles []string, files outputFiles, config ass.AssembleConfig, fullSize int32) int {
+	if len(inputFiles) == 0 {
+		fmt.Printf("Please give the object file names")
+		return -1
+	}
+	objects := make([]ass.Object, 0, len(inputFiles))
+	for _, inputFile := range inputFiles {
+		content, err := readAllBytes(inputFile)
+		if err == nil {
+			var obj ass.Object
+			obj, err = ass.ReadObject(filepath.Base(inputFile), content)
+			objects = append(objects, obj)
+		}
+		if err != nil {
+			fmt.Printf("File %s reading error: %v\n", inputFile, err)
+			return -1
+		}
+	}
+	print("Linking...")
+	instrs, builded, err := ass.Link(objects, config, fullSize)
+	if !printDiagnostics(builded.Diagnostics, err) {
+		return -1
+	}
+	return cliOutput(instrs, builded, files, "", false)
+}
+
+// print the used and free space of each section, and of the whole image
+func printUsage(builded ass.AssembleResult) {
+	usage := builded.Usage()
+	if len(usage) == 0 {
+		return
+	}
+	fmt.Printf("%-10s  %-10s  %-10s  %s\n", "Section", "Start", "Used", "Free")
+	used := uint32(0)
+	for _, sec := range usage {
+		fmt.Printf("%-10s  0x%08x  0x%08x  0x%08x\n", "."+sec.Name, sec.Start, sec.Used, sec.Free)
+		used += sec.Used
+	}
+	if size := builded.Full.End - builded.Full.Start; size > 0 {
+		fmt.Printf("Image: used 0x%08x, free 0x%08x of 0x%08x (%.1f%% used)\n", used, size-used, size, float64(used)*100/float64(size))
+	}
+	for _, mem := range builded.Memories {
+		used = 0
+		for _, sec := range usage {
+			if sec.Memory == mem.Name {
+				used += sec.Used
+			}
+		}
+		if size := mem.Full.End - mem.Full.Start; size > 0 {
+			fmt.Printf("Memory %s: [0x%08x, 0x%08x), used 0x%08x, free 0x%08x (%.1f%% used)\n", mem.Name, mem.Full.Start, mem.Full.End, used, size-used, float64(used)*100/float64(size))
+		}
+	}
+}
+
+// write the outputs of as and link, name is the name of the object when relocatable
+func cliOutput(instrs []ins.Instruction, builded ass.AssembleResult, files outputFiles, name string, relocatable bool) int {
+	var err error
 	println("Instruction count:", len(instrs))
 	fmt.Printf("Full segment: [0x%08x, 0x%08x), size: 0x%08x\n", builded.Full.Start, builded.Full.End, builded.Full.End-builded.Full.Start)
 	fmt.Printf("Data segment: [0x%08x, 0x%08x), size: 0x%08x\n", builded.Data.Start, builded.Data.End, builded.Data.End-builded.Data.Start)
 	fmt.Printf("Text segment: [0x%08x, 0x%08x), size: 0x%08x\n", builded.Text.Start, builded.Text.End, builded.Text.End-builded.Text.Start)
-	if bitsFile != "" {
-		err = writeAllLines(bitsFile, toBitStrings(ins.ToBin(instrs)))
+	printUsage(builded)
+	if files.bitsFile != "" {
+		err = writeAllLines(files.bitsFile, toBitStrings(ins.ToBin(instrs)))
 		if err != nil {
 			println("Generate bit string file failed", err)
-			return -1, nil, nil
+			return -1
 		}
-		println("Bit string file:", bitsFile)
+		println("Bit string file:", files.bitsFile)
 	}
-	if asmFile != "" {
-		err = writeAllLines(asmFile, toASMs(instrs))
+	if files.asmFile != "" {
+		err = writeAllLines(files.asmFile, toASMs(instrs))
 		if err != nil {
 			println("Generate asm file failed", err)
-			return -1, nil, nil
+			return -1
 		}
-		println("ASM file:", asmFile)
+		println("ASM file:", files.asmFile)
 	}
-	if binFile != "" {
-		err = writeAllBytes(binFile, builded.Bin)
+	if len(builded.Memories) > 0 {
+		for _, mem := range builded.Memories {
+			image := memfile.Image{Base: mem.Full.Start, Data: mem.Bin, Entry: builded.Entry, HasEntry: mem.Name == "text"}
+			image.Parts = recordParts(builded, mem)
+			if writeImageFiles(image, files.forMemory(mem.Name)) != 0 {
+				return -1
+			}
+		}
+	} else {
+		image := memfile.Image{Base: 0, Data: builded.Bin, Entry: builded.Entry, HasEntry: true}
+		if !relocatable { // the sections of an object all start at 0
+			image.Parts = recordParts(builded, ass.Memory{})
+		}
+		if writeImageFiles(image, files) != 0 {
+			return -1
+		}
+	}
+	if files.lstFile != "" {
+		err = writeAllLines(files.lstFile, toListing(builded))
 		if err != nil {
-			println("Generate asm file failed", err)
-			return -1, nil, nil
+			println("Generate listing file failed", err)
+			return -1
 		}
-		println("Bin file:", binFile)
+		println("Listing file:", files.lstFile)
 	}
-	if mifFile != "" {
-		err = writeAllLines(mifFile, toMIF(builded.Bin))
+	if files.elfFile != "" {
+		elf := builded.ToELF()
+		if relocatable {
+			elf = builded.Object(name).ToELF()
+		}
+		err = writeAllBytes(files.elfFile, elf)
 		if err != nil {
-			println("Generate mif file failed", err)
-			return -1, nil, nil
+			println("Generate ELF file failed", err)
+			return -1
 		}
-		println("MIF file:", mifFile)
+		println("ELF file:", files.elfFile)
 	}
-	return 0, instrs, &builded
+	if files.mapFile != "" {
+		err = writeAllLines(files.mapFile, toMap(builded.Symbols))
+		if err != nil {
+			println("Generate map file failed", err)
+			return -1
+		}
+		println("Map file:", files.mapFile)
+	}
+	if files.mapJSONFile != "" {
+		bytes, _ := json.MarshalIndent(builded.Symbols, "", "  ")
+		err = writeAllBytes(files.mapJSONFile, append(bytes, '\n'))
+		if err != nil {
+			println("Generate JSON map file failed", err)
+			return -1
+		}
+		println("JSON map file:", files.mapJSONFile)
+	}
+	return 0
 }
 
 func usage() {
 	fmt.Fprintf(os.Stderr, `mip version: mip/0.0.1
-Usage: mip [options] as/sim/dump [inputFile]
+Usage: mip [options] as/link/sim/dump/test [inputFile...]
 
 Options:
 `)
@@ -80,27 +352,75 @@ Options:
 Examples:
 Assemble:
 $ mip -asm output.asm -bin output.bin -mif output.mif -data 0x3000 -text 0x1000 -size 0x4000 as input.asm
+$ mip -coe output.coe -memh output.hex -width 32 -depth 4096 -data 0x3000 -text 0x1000 -size 0x4000 as input.asm
+$ mip -harvard -bin output.bin -mif output.mif -width 32 -text 0x0 -data 0x0 -textsize 0x1000 -datasize 0x800 as input.asm
+$ mip -lst output.lst -map output.map -mapjson output.json -data 0x3000 -text 0x1000 as input.asm
+$ mip -I ./lib -bin output.bin -size 0x4000 as input.asm
+$ mip -relax -bin output.bin -size 0x40000 as input.asm
+$ mip -elf output.elf -data 0x3000 -text 0x1000 as input.asm
+$ mip -c -elf input.o as input.asm
+$ mip -bin output.bin -data 0x3000 -text 0x1000 -section ktext=0x0180 -section kdata=0x3800 -size 0x4000 as input.asm
+Link:
+$ mip -elf output.elf -bin output.bin -data 0x3000 -text 0x1000 -size 0x4000 link main.o lib.o
 Simulate:
 $ mip -bin output.bin -entry 0x1000 sim
+$ mip -elf output.elf sim
+$ mip -harvard -bin output.bin -text 0x0 -data 0x0 -entry 0x0 sim
 Dump:
 $ mip -asm output.asm -bin output.bin -text 0x1000 -size 0x1000 dump
+$ mip -map output.map -bin output.bin -text 0x1000 -size 0x1000 dump
+Test:
+$ mip test
 `)
 }
 
 func cliMain() int {
-	var asmFile, binFile, bitsFile, mifFile, verb, inputFile string
+	var asmFile, binFile, bitsFile, mifFile, lstFile, mapFile, mapJSONFile, elfFile, verb, inputFile string
+	var coeFile, memhFile, membFile, hexFile, srecFile, logisimFile, byteOrder, endian, isa string
 	var textSegment, dataSegment uint64
 	var fullSize, entry int64
-	var helpFlag bool
+	var memWidth, memDepth, addressRadix, logisimVersion int
+	var harvardFlag bool
+	var codeSize, dataSize uint64
+	var helpFlag, relaxFlag, objectFlag, noReorderFlag, noDelayFlag, loadDelayFlag bool
+	var includeDirs stringList
+	sections := make(sectionBases)
 	flag.BoolVar(&helpFlag, "help", false, "Show help screen")
 	flag.StringVar(&asmFile, "asm", "", "ASM file name")
 	flag.StringVar(&binFile, "bin", "", "Bin file name")
 	flag.StringVar(&mifFile, "mif", "", "Mif file name")
+	flag.StringVar(&coeFile, "coe", "", "Xilinx COE file name")
+	flag.StringVar(&memhFile, "memh", "", "Verilog $readmemh file name")
+	flag.StringVar(&membFile, "memb", "", "Verilog $readmemb file name")
+	flag.StringVar(&hexFile, "hex", "", "Intel HEX file name, written by as and loaded by sim and dump")
+	flag.StringVar(&srecFile, "srec", "", "Motorola S-record file name, written by as and loaded by sim and dump")
+	flag.StringVar(&logisimFile, "logisim", "", "Logisim ROM/RAM image file name, written by as and loaded by sim and dump")
+	flag.IntVar(&logisimVersion, "logisimver", 2, "Format of the Logisim image written: 2 for v2.0 raw, 3 for v3.0 hex words addressed")
+	flag.IntVar(&memWidth, "width", 0, "Word width in bits of mif/coe/memh/memb/logisim: 8, 16 or 32, 0 for 8 of mif and 32 of the others")
+	flag.IntVar(&memDepth, "depth", 0, "Depth in words of mif/coe/memh/memb/logisim, 0 for the size of the image")
+	flag.StringVar(&byteOrder, "byteorder", "", "Order of the bytes in a word of mif/coe/memh/memb/logisim: little or big, empty for -endian")
+	flag.StringVar(&endian, "endian", "little", "Byte order of the words and the halves in as, link, sim and dump: little or big")
+	flag.StringVar(&isa, "isa", "mips32r1", "Instruction set of as, link and sim: mips32r1, or mips32r2 for ext, ins, seb, seh, wsbh, rotr, rotrv, di, ei and rdhwr")
+	flag.IntVar(&addressRadix, "addrradix", 16, "Address radix of mif/memh/memb: 2, 8, 10 or 16")
 	flag.StringVar(&bitsFile, "bits", "", "Bit string file name")
+	flag.StringVar(&lstFile, "lst", "", "Listing file name")
+	flag.StringVar(&elfFile, "elf", "", "ELF executable file name, written by as and loaded by sim")
+	flag.StringVar(&mapFile, "map", "", "Symbol map file name, read by dump to annotate addresses")
+	flag.StringVar(&mapJSONFile, "mapjson", "", "Symbol map file name in JSON")
 	flag.Uint64Var(&textSegment, "text", 0, "Starting address of text segment")
 	flag.Uint64Var(&dataSegment, "data", 0, "Starting address of data segment")
+	flag.Var(sections, "section", "Starting address of another section as name=address, like rodata=0x2000, can be given multiple times")
 	flag.Int64Var(&entry, "entry", -1, "Program entry point, negtive for default (start of text segment)")
 	flag.Int64Var(&fullSize, "size", -1, "Full size of program, negtive for no bin data")
+	flag.Var(&includeDirs, "I", "Directory to search for .include files, can be given multiple times")
+	flag.BoolVar(&relaxFlag, "relax", false, "Expand branches and jumps out of range instead of reporting errors")
+	flag.BoolVar(&noReorderFlag, "noreorder", false, "Start in .set noreorder, where the delay slots are written by hand, instead of filling them")
+	flag.BoolVar(&noDelayFlag, "nodelay", false, "Run branches and jumps in sim without delay slots, like MARS, and assemble -asm with -noreorder")
+	flag.BoolVar(&loadDelayFlag, "loaddelay", false, "Make the value of a load seen in sim from the second instruction after it, like MIPS I, and assemble -asm without moving loads into delay slots")
+	flag.BoolVar(&objectFlag, "c", false, "Assemble into a relocatable object, written by -elf, for link")
+	flag.BoolVar(&harvardFlag, "harvard", false, "Separate instruction and data memories: images of text and data from their starting addresses, and split memories in sim")
+	flag.Uint64Var(&codeSize, "textsize", 0, "Size of the instruction memory with -harvard, 0 for -size")
+	flag.Uint64Var(&dataSize, "datasize", 0, "Size of the data memory with -harvard, 0 for -size")
 	flag.Usage = usage
 
 	flag.Parse()
@@ -113,47 +433,139 @@ func cliMain() int {
 	verb = flag.Arg(0)
 	inputFile = flag.Arg(1)
 
+	if byteOrder == "" {
+		byteOrder = endian
+	}
+	if endian != "little" && endian != "big" {
+		fmt.Printf("Invalid endianness %s, expect little or big\n", endian)
+		return -1
+	}
+	revisions := map[string]int{"mips32r1": ins.MIPS32R1, "mips32r2": ins.MIPS32R2}
+	if _, ok := revisions[isa]; !ok {
+		fmt.Printf("Invalid instruction set %s, expect mips32r1 or mips32r2\n", isa)
+		return -1
+	}
+	if byteOrder != "little" && byteOrder != "big" {
+		fmt.Printf("Invalid byte order %s, expect little or big\n", byteOrder)
+		return -1
+	}
+	files := outputFiles{bitsFile, asmFile, binFile, mifFile, lstFile, mapFile, mapJSONFile, elfFile, coeFile, memhFile, membFile, hexFile, srecFile, logisimFile,
+		memfile.Options{Width: memWidth, Depth: memDepth, BigEndian: byteOrder == "big", AddressRadix: addressRadix}, logisimVersion}
+	config := ass.AssembleConfig{Data: uint32(dataSegment), Text: uint32(textSegment), Sections: sections, IncludeDirs: includeDirs, Relax: relaxFlag, Relocatable: objectFlag,
+		Harvard: harvardFlag, CodeSize: uint32(codeSize), DataSize: uint32(dataSize), BigEndian: endian == "big", NoReorder: noReorderFlag,
+		ISARevision: revisions[isa]}
+
 	switch verb {
 	case "as":
-		retcode, _, _ := cliAs(inputFile, bitsFile, asmFile, binFile, mifFile, uint32(dataSegment), uint32(textSegment), int32(fullSize))
+		retcode, _, _ := cliAs(inputFile, files, config, int32(fullSize))
 		return retcode
+	case "link":
+		return cliLink(flag.Args()[1:], files, config, int32(fullSize))
 	case "sim":
 		var _entry uint32
-		if binFile != "" {
+		// the unified memory, or the split ones with -harvard
+		var image, code, data []uint8
+		var codeBase, dataBase uint32
+		if binFile != "" || logisimFile != "" {
 			if entry < 0 {
 				fmt.Printf("Must give entry point for bin file\n")
 				return -1
 			}
-			content, err := readAllBytes(binFile)
+			// the images without addresses, from word 0 of the memory
+			read := func(files outputFiles) ([]uint8, error) {
+				if files.binFile != "" {
+					return readAllBytes(files.binFile)
+				}
+				return readLogisimFile(files.logisimFile, files.memory)
+			}
+			var err error
+			if harvardFlag {
+				code, err = read(files.forMemory("text"))
+				if err == nil {
+					data, err = read(files.forMemory("data"))
+				}
+				codeBase, dataBase = config.Text, config.Data
+			} else {
+				image, err = read(files)
+			}
 			if err != nil {
-				fmt.Printf("File %s reading error: %v\n", binFile, err)
+				fmt.Printf("Bin file reading error: %v\n", err)
 				return -1
 			}
 
-			print("Initializing for simulating...")
-			if !sim.Initialize(content, breakHandler, nil) {
-				println("failed")
+			_entry = uint32(entry)
+		} else if hexFile != "" || srecFile != "" {
+			srec := hexFile == ""
+			pick := func(files outputFiles) string {
+				if srec {
+					return files.srecFile
+				}
+				return files.hexFile
+			}
+			var codeImage, dataImage memfile.Image
+			var err error
+			if harvardFlag {
+				codeImage, err = readRecordFile(pick(files.forMemory("text")), srec)
+				if err == nil {
+					dataImage, err = readRecordFile(pick(files.forMemory("data")), srec)
+				}
+				code, codeBase, data, dataBase = codeImage.Data, codeImage.Base, dataImage.Data, dataImage.Base
+			} else {
+				codeImage, err = readRecordFile(pick(files), srec)
+				image = codeImage.Flat()
+			}
+			if err != nil {
+				fmt.Printf("Record file reading error: %v\n", err)
 				return -1
 			}
 
-			_entry = uint32(entry)
+			if entry >= 0 {
+				_entry = uint32(entry)
+			} else if codeImage.HasEntry {
+				_entry = codeImage.Entry
+			} else {
+				fmt.Printf("Must give entry point for the file without one\n")
+				return -1
+			}
+		} else if elfFile != "" {
+			var elfEntry uint32
+			var err error
+			if harvardFlag {
+				isCode := func(prog *elf.Prog) bool { return prog.Flags&elf.PF_X != 0 }
+				code, elfEntry, err = readELF(elfFile, config.BigEndian, isCode)
+				if err == nil {
+					data, _, err = readELF(elfFile, config.BigEndian, func(prog *elf.Prog) bool { return !isCode(prog) })
+				}
+			} else {
+				image, elfEntry, err = readELF(elfFile, config.BigEndian, nil)
+			}
+			if err != nil {
+				fmt.Printf("File %s reading error: %v\n", elfFile, err)
+				return -1
+			}
 
-			println("done")
+			if entry < 0 {
+				_entry = elfEntry
+			} else {
+				_entry = uint32(entry)
+			}
 		} else if asmFile != "" {
-			retcode, _, buildedptr := cliAs(asmFile, "", "", "", "", uint32(dataSegment), uint32(textSegment), int32(fullSize))
+			config.Relocatable = false
+			config.NoReorder = config.NoReorder || noDelayFlag // a filled delay slot is skipped by a taken branch
+			config.LoadDelay = loadDelayFlag
+			retcode, _, buildedptr := cliAs(asmFile, outputFiles{lstFile: lstFile}, config, int32(fullSize))
 			if retcode != 0 {
 				return retcode
 			}
 			builded := *buildedptr
-			if builded.Full.End == 0 {
+			if harvardFlag {
+				code, codeBase = builded.Memories[0].Bin, builded.Memories[0].Full.Start
+				data, dataBase = builded.Memories[1].Bin, builded.Memories[1].Full.Start
+			} else if builded.Full.End == 0 {
 				println("No bin data. Stop simulating")
 				return -1
-			}
-
-			print("Initializing for simulating...")
-			if !sim.Initialize(builded.Bin, breakHandler, nil) {
-				println("failed")
-				return -1
+			} else {
+				image = builded.Bin
 			}
 
 			if entry < 0 {
@@ -161,12 +573,24 @@ func cliMain() int {
 			} else {
 				_entry = uint32(entry)
 			}
-
-			println("done")
 		} else {
 			fmt.Printf("Please give the input file name.")
 			return -1
 		}
+
+		print("Initializing for simulating...")
+		var ok bool
+		simConfig := sim.Config{BigEndian: config.BigEndian, NoDelaySlot: noDelayFlag, LoadDelay: loadDelayFlag, ISARevision: config.ISARevision}
+		if harvardFlag {
+			ok = sim.InitializeSplit(code, codeBase, data, dataBase, simConfig, breakHandler, nil)
+		} else {
+			ok = sim.Initialize(image, simConfig, breakHandler, nil)
+		}
+		if !ok {
+			println("failed")
+			return -1
+		}
+		println("done")
 		println("Executing...")
 		flg := sim.Execute(_entry, false)
 		println("Executed:", flg)
@@ -174,30 +598,58 @@ func cliMain() int {
 		sim.ShowRegisters()
 		return 0
 	case "dump":
-		if binFile == "" {
-			fmt.Printf("Please give the bin file name.")
+		var content []uint8
+		var err error
+		switch {
+		case binFile != "":
+			content, err = readAllBytes(binFile)
+		case logisimFile != "":
+			content, err = readLogisimFile(logisimFile, files.memory)
+		case hexFile != "" || srecFile != "":
+			var image memfile.Image
+			if hexFile != "" {
+				image, err = readRecordFile(hexFile, false)
+			} else {
+				image, err = readRecordFile(srecFile, true)
+			}
+			content = image.Flat()
+		default:
+			fmt.Printf("Please give the bin, hex, srec or logisim file name.")
 			return -1
 		}
-		content, err := readAllBytes(binFile)
 		if err != nil {
-			fmt.Printf("File %s reading error: %v\n", binFile, err)
+			fmt.Printf("File reading error: %v\n", err)
 			return -1
 		}
 		print("Dumping...")
 		from := textSegment
+		for fullSize > 0 && uint64(len(content)) < from+uint64(fullSize) {
+			content = append(content, 0) // the records have no bytes after the last one
+		}
 		bin := make([]uint32, 0)
-		for i := 0; i < int(fullSize); i += 4 {
-			var result uint32 = 0
-			for j := 0; j < 4; j++ {
-				result |= uint32(content[uint32(from)+uint32(i+j)]) << (uint32(j) << 3)
-			}
-			bin = append(bin, result)
+		if fullSize > 0 {
+			bin = dum.ToWords(content[from:from+uint64(fullSize)], config.BigEndian)
 		}
 		instrs := dum.DumpText(bin)
 		println("done")
-		strs := toASMs(instrs)
-		for i, s := range strs {
-			strs[i] = fmt.Sprintf("0x%08x: %s", uint32(from)+uint32(i), s)
+		labels := make(map[uint32][]string)
+		if mapFile != "" {
+			content, err := readAllLines(mapFile)
+			if err == nil {
+				labels, err = fromMap(content)
+			}
+			if err != nil {
+				fmt.Printf("File %s reading error: %v\n", mapFile, err)
+				return -1
+			}
+		}
+		strs := make([]string, 0, len(instrs))
+		for i, s := range toASMs(instrs) {
+			addr := uint32(from) + uint32(i)<<2
+			for _, name := range labels[addr] {
+				strs = append(strs, name+":")
+			}
+			strs = append(strs, fmt.Sprintf("0x%08x: %s", addr, s))
 		}
 		if asmFile != "" {
 			err = writeAllLines(asmFile, strs)
@@ -212,6 +664,11 @@ func cliMain() int {
 			}
 		}
 		return 0
+	case "test":
+		if !runSelfTests() {
+			return -1
+		}
+		return 0
 	default:
 		println("No this verb.")
 		return -1
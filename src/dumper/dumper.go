@@ -1,6 +1,8 @@
 package dumper
 
 import (
+	"encoding/binary"
+
 	ins "../instruction"
 )
 
@@ -11,3 +13,16 @@ func DumpText(bin []uint32) []ins.Instruction {
 	}
 	return result
 }
+
+// ToWords groups the bytes of the image into the words of the instructions in the byte order
+func ToWords(content []uint8, bigEndian bool) []uint32 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	result := make([]uint32, 0, len(content)>>2)
+	for i := 0; i+4 <= len(content); i += 4 {
+		result = append(result, order.Uint32(content[i:]))
+	}
+	return result
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+    "debug/elf"
     "fmt"
     "bufio"
     "io"
     "os"
-    "strings"
     "strconv"
+    "strings"
 
+    ass "./assembler"
     ins "./instruction"
+    "./memfile"
+    mem "./simulator/memory"
 )
 
 
@@ -28,20 +32,147 @@ func toBitStrings(bin []uint32) []string {
 	return result
 }
 
-func toMIF(bin []uint8) []string {
-	result := make([]string, 0, len(bin))
-	result = append(result, "WIDTH=8;")
-	result = append(result, fmt.Sprintf("DEPTH=%d;", len(bin)))
-	result = append(result, "ADDRESS_RADIX=HEX;")
-	result = append(result, "DATA_RADIX=HEX;")
-	result = append(result, "CONTENT BEGIN")
-	for i, bits := range bin {
-		result = append(result, fmt.Sprintf("    %04X : %04X;", i, bits))
+const listingRow = "%-8s  %-23s  %-28s  %s"
+
+// toListing shows the addresses, the code or data and the expansion of each source line, then the symbols
+func toListing(builded ass.AssembleResult) []string {
+	result := make([]string, 0, len(builded.Listing)+len(builded.Symbols)+4)
+	result = append(result, fmt.Sprintf(listingRow, "Address", "Code", "Expansion", "Source"))
+	for _, line := range builded.Listing {
+		source := fmt.Sprintf("%s  %s", ass.Position{File: line.Source.File, Line: line.Source.Line}, line.Source.Text)
+		for i, instr := range line.Instrs {
+			result = append(result, strings.TrimRight(fmt.Sprintf(listingRow, fmt.Sprintf("%08x", line.Address+uint32(i)<<2), fmt.Sprintf("%08x", instr.ToBits()), instr.ToASM(), source), " "))
+			source = ""
+		}
+		for i := 0; i < len(line.Data) || i == 0; i += 8 {
+			if len(line.Instrs) > 0 {
+				break
+			}
+			bytes := make([]string, 0, 8)
+			for j := i; j < i+8 && j < len(line.Data); j++ {
+				bytes = append(bytes, fmt.Sprintf("%02x", line.Data[j]))
+			}
+			result = append(result, strings.TrimRight(fmt.Sprintf(listingRow, fmt.Sprintf("%08x", line.Address+uint32(i)), strings.Join(bytes, " "), "", source), " "))
+			source = ""
+		}
+	}
+
+	result = append(result, "", "Symbols:")
+	for _, symbol := range builded.Symbols {
+		if symbol.Kind == ass.SYMBOL_LABEL {
+			result = append(result, fmt.Sprintf("%08x  %s", symbol.Address, symbol.Name))
+		}
+	}
+	return result
+}
+
+// toMap writes the symbol table as "address size segment kind name" lines
+func toMap(symbols []ass.Symbol) []string {
+	result := make([]string, 0, len(symbols)+1)
+	result = append(result, fmt.Sprintf("# %-8s  %-8s  %-7s  %-8s  %s", "Address", "Size", "Segment", "Kind", "Name"))
+	for _, symbol := range symbols {
+		result = append(result, fmt.Sprintf("%08x    %08x  %-7s  %-8s  %s", symbol.Address, symbol.Size, symbol.Segment, symbol.Kind, symbol.Name))
 	}
-	result = append(result, "END;")
 	return result
 }
 
+// fromMap reads the labels of a map file by their addresses
+func fromMap(content []string) (map[uint32][]string, error) {
+	result := make(map[uint32][]string)
+	for i, line := range content {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: expect address, size, segment, kind and name", i+1)
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %s", i+1, fields[0])
+		}
+		if fields[3] == ass.SYMBOL_LABEL {
+			result[uint32(addr)] = append(result[uint32(addr)], fields[4])
+		}
+	}
+	return result, nil
+}
+
+// readELF loads the segments of an ELF executable of the byte order into a flat image for the simulator,
+// only the ones taken by only if it isn't nil
+func readELF(path string, bigEndian bool, only func(prog *elf.Prog) bool) ([]uint8, uint32, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	if file.Class != elf.ELFCLASS32 || file.Machine != elf.EM_MIPS {
+		return nil, 0, fmt.Errorf("not an ELF32 MIPS file")
+	}
+	if file.Type != elf.ET_EXEC {
+		return nil, 0, fmt.Errorf("not an executable")
+	}
+	if (file.Data == elf.ELFDATA2MSB) != bigEndian {
+		return nil, 0, fmt.Errorf("the byte order is %v, give -endian to match it", file.Data)
+	}
+	image := make([]uint8, 0)
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Memsz == 0 || (only != nil && !only(prog)) {
+			continue
+		}
+		end := prog.Vaddr + prog.Memsz
+		if end > uint64(mem.MEMORY_SIZE) {
+			return nil, 0, fmt.Errorf("segment [0x%08x, 0x%08x) is out of the simulator memory", prog.Vaddr, end)
+		}
+		for uint64(len(image)) < end {
+			image = append(image, 0)
+		}
+		if prog.Filesz == 0 {
+			continue // like .bss
+		}
+		if _, err := prog.ReadAt(image[prog.Vaddr:prog.Vaddr+prog.Filesz], 0); err != nil {
+			return nil, 0, err
+		}
+	}
+	return image, uint32(file.Entry), nil
+}
+
+// readRecordFile loads the image of the Intel HEX file, or the S-record file if srec
+func readRecordFile(path string, srec bool) (memfile.Image, error) {
+	content, err := readAllLines(path)
+	if err != nil {
+		return memfile.Image{}, err
+	}
+	read := memfile.ReadIntelHex
+	if srec {
+		read = memfile.ReadSRecord
+	}
+	image, err := read(content)
+	if err != nil {
+		return memfile.Image{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if end := uint64(image.Base) + uint64(len(image.Data)); end > uint64(mem.MEMORY_SIZE) {
+		return memfile.Image{}, fmt.Errorf("%s: the image [0x%08x, 0x%08x) is out of the simulator memory", path, image.Base, end)
+	}
+	return image, nil
+}
+
+// readLogisimFile loads the bytes of the Logisim image, of 32-bit words if the width isn't given
+func readLogisimFile(path string, opt memfile.Options) ([]uint8, error) {
+	content, err := readAllLines(path)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Width == 0 {
+		opt.Width = 32
+	}
+	image, err := memfile.FromLogisim(content, opt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return image, nil
+}
+
 func readAllLines(path string) ([]string, error) {
     file, err := os.OpenFile(path, os.O_RDONLY, 0666)
     if err != nil {
@@ -60,7 +191,7 @@ func readAllLines(path string) ([]string, error) {
 
     for {
         line, _, err := buf.ReadLine()
-        str := strings.TrimSpace(string(line))
+        str := string(line)
         if err != nil {
             if err == io.EOF {
                 break
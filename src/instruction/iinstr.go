@@ -40,6 +40,37 @@ const (
 	OP_SLTIU    = 0x0b
 	OP_SPECIAL  = 0x00
 	OP_SPECIAL2 = 0x1c
+	OP_SPECIAL3 = 0x1f
+	OP_COP0     = 0x10
+	OP_REGIMM   = 0x01
+	OP_LWL      = 0x22
+	OP_LWR      = 0x26
+	OP_SWL      = 0x2a
+	OP_SWR      = 0x2e
+	OP_CACHE    = 0x2f
+	OP_PREF     = 0x33
+	OP_BEQL     = 0x14
+	OP_BNEL     = 0x15
+	OP_BLEZL    = 0x16
+	OP_BGTZL    = 0x17
+)
+
+// the rt field of the REGIMM instructions
+const (
+	RT_BLTZ    = 0x00
+	RT_BGEZ    = 0x01
+	RT_BLTZL   = 0x02
+	RT_BGEZL   = 0x03
+	RT_TGEI    = 0x08
+	RT_TGEIU   = 0x09
+	RT_TLTI    = 0x0a
+	RT_TLTIU   = 0x0b
+	RT_TEQI    = 0x0c
+	RT_TNEI    = 0x0e
+	RT_BLTZAL  = 0x10
+	RT_BGEZAL  = 0x11
+	RT_BLTZALL = 0x12
+	RT_BGEZALL = 0x13
 )
 
 func (this IInstruction) GetToken() string {
@@ -49,14 +80,16 @@ func (this IInstruction) GetToken() string {
 func (this IInstruction) ToASM() string {
 	if this.Opcode == OP_ADDI || this.Opcode == OP_ADDIU || this.Opcode == OP_ANDI || this.Opcode == OP_ORI || this.Opcode == OP_XORI || this.Opcode == OP_SLTI || this.Opcode == OP_SLTIU {
 		return fmt.Sprintf("%-7s $%d, $%d, 0x%x", this.Token, this.Rt, this.Rs, this.Imm)
-	} else if this.Opcode == OP_BEQ || this.Opcode == OP_BNE {
+	} else if this.Opcode == OP_BEQ || this.Opcode == OP_BNE || this.Opcode == OP_BEQL || this.Opcode == OP_BNEL {
 		return fmt.Sprintf("%-7s $%d, $%d, 0x%x", this.Token, this.Rs, this.Rt, this.Imm)
 	} else if this.Opcode == OP_LUI {
 		return fmt.Sprintf("%-7s $%d, 0x%x", this.Token, this.Rt, this.Imm)
-	} else if this.Opcode == OP_LW || this.Opcode == OP_SW || this.Opcode == OP_LB || this.Opcode == OP_SB || this.Opcode == OP_LBU || this.Opcode == OP_LH || this.Opcode == OP_LHU || this.Opcode == OP_SH {
+	} else if this.Opcode == OP_LW || this.Opcode == OP_SW || this.Opcode == OP_LB || this.Opcode == OP_SB || this.Opcode == OP_LBU || this.Opcode == OP_LH || this.Opcode == OP_LHU || this.Opcode == OP_SH || this.Opcode == OP_LWL || this.Opcode == OP_LWR || this.Opcode == OP_SWL || this.Opcode == OP_SWR {
 		return fmt.Sprintf("%-7s $%d, 0x%x($%d)", this.Token, this.Rt, this.Imm, this.Rs)
-	} else if this.Opcode == OP_BGEZ || this.Opcode == OP_BGEZAL || this.Opcode == OP_BLTZ || this.Opcode == OP_BLTZAL || this.Opcode == OP_BLEZ || this.Opcode == OP_BGTZ {
+	} else if this.Opcode == OP_REGIMM || this.Opcode == OP_BLEZ || this.Opcode == OP_BGTZ || this.Opcode == OP_BLEZL || this.Opcode == OP_BGTZL {
 		return fmt.Sprintf("%-7s $%d, 0x%x", this.Token, this.Rs, this.Imm)
+	} else if this.Opcode == OP_CACHE || this.Opcode == OP_PREF { // the operation or the hint is in rt
+		return fmt.Sprintf("%-7s 0x%x, 0x%x($%d)", this.Token, this.Rt, this.Imm, this.Rs)
 	} else {
 		panic(errors.New(fmt.Sprintf("No this instr %d", this.Opcode)))
 	}
@@ -113,16 +146,56 @@ func ParseI(bits uint32) IInstruction {
 		result.Token = "blez"
 	case OP_BGTZ:
 		result.Token = "bgtz"
-	case OP_BGEZ:
+	case OP_LWL:
+		result.Token = "lwl"
+	case OP_LWR:
+		result.Token = "lwr"
+	case OP_SWL:
+		result.Token = "swl"
+	case OP_SWR:
+		result.Token = "swr"
+	case OP_CACHE:
+		result.Token = "cache"
+	case OP_PREF:
+		result.Token = "pref"
+	case OP_BEQL:
+		result.Token = "beql"
+	case OP_BNEL:
+		result.Token = "bnel"
+	case OP_BLEZL:
+		result.Token = "blezl"
+	case OP_BGTZL:
+		result.Token = "bgtzl"
+	case OP_REGIMM:
 		switch result.Rt {
-		case 0x01:
+		case RT_BGEZ:
 			result.Token = "bgez"
-		case 0x11:
+		case RT_BGEZAL:
 			result.Token = "bgezal"
-		case 0x00:
+		case RT_BLTZ:
 			result.Token = "bltz"
-		case 0x20:
+		case RT_BLTZAL:
 			result.Token = "bltzal"
+		case RT_BGEZL:
+			result.Token = "bgezl"
+		case RT_BGEZALL:
+			result.Token = "bgezall"
+		case RT_BLTZL:
+			result.Token = "bltzl"
+		case RT_BLTZALL:
+			result.Token = "bltzall"
+		case RT_TGEI:
+			result.Token = "tgei"
+		case RT_TGEIU:
+			result.Token = "tgeiu"
+		case RT_TLTI:
+			result.Token = "tlti"
+		case RT_TLTIU:
+			result.Token = "tltiu"
+		case RT_TEQI:
+			result.Token = "teqi"
+		case RT_TNEI:
+			result.Token = "tnei"
 		}
 	}
 	return result
@@ -205,21 +278,101 @@ func Blez(rs uint8, imm uint16) IInstruction {
 }
 
 func Bgtz(rs uint8, imm uint16) IInstruction {
-	return CreateI("bgez", OP_BGTZ, rs, 0x00, imm)
+	return CreateI("bgtz", OP_BGTZ, rs, 0x00, imm)
 }
 
 func Bgez(rs uint8, imm uint16) IInstruction {
-	return CreateI("bgez", OP_BGEZ, rs, 0x01, imm)
+	return CreateI("bgez", OP_BGEZ, rs, RT_BGEZ, imm)
 }
 
 func Bgezal(rs uint8, imm uint16) IInstruction {
-	return CreateI("bgezal", OP_BGEZAL, rs, 0x11, imm)
+	return CreateI("bgezal", OP_BGEZAL, rs, RT_BGEZAL, imm)
 }
 
 func Bltz(rs uint8, imm uint16) IInstruction {
-	return CreateI("bltz", OP_BLTZ, rs, 0x00, imm)
+	return CreateI("bltz", OP_BLTZ, rs, RT_BLTZ, imm)
 }
 
 func Bltzal(rs uint8, imm uint16) IInstruction {
-	return CreateI("bltzal", OP_BLTZAL, rs, 0x20, imm)
+	return CreateI("bltzal", OP_BLTZAL, rs, RT_BLTZAL, imm)
+}
+
+func Lwl(rt uint8, rs uint8, imm uint16) IInstruction {
+	return CreateI("lwl", OP_LWL, rs, rt, imm)
+}
+
+func Lwr(rt uint8, rs uint8, imm uint16) IInstruction {
+	return CreateI("lwr", OP_LWR, rs, rt, imm)
+}
+
+func Swl(rt uint8, rs uint8, imm uint16) IInstruction {
+	return CreateI("swl", OP_SWL, rs, rt, imm)
+}
+
+func Swr(rt uint8, rs uint8, imm uint16) IInstruction {
+	return CreateI("swr", OP_SWR, rs, rt, imm)
+}
+
+func Cache(op uint8, rs uint8, imm uint16) IInstruction {
+	return CreateI("cache", OP_CACHE, rs, op, imm)
+}
+
+func Pref(hint uint8, rs uint8, imm uint16) IInstruction {
+	return CreateI("pref", OP_PREF, rs, hint, imm)
+}
+
+func Beql(rs uint8, rt uint8, imm uint16) IInstruction {
+	return CreateI("beql", OP_BEQL, rs, rt, imm)
+}
+
+func Bnel(rs uint8, rt uint8, imm uint16) IInstruction {
+	return CreateI("bnel", OP_BNEL, rs, rt, imm)
+}
+
+func Blezl(rs uint8, imm uint16) IInstruction {
+	return CreateI("blezl", OP_BLEZL, rs, 0x00, imm)
+}
+
+func Bgtzl(rs uint8, imm uint16) IInstruction {
+	return CreateI("bgtzl", OP_BGTZL, rs, 0x00, imm)
+}
+
+func Bgezl(rs uint8, imm uint16) IInstruction {
+	return CreateI("bgezl", OP_REGIMM, rs, RT_BGEZL, imm)
+}
+
+func Bgezall(rs uint8, imm uint16) IInstruction {
+	return CreateI("bgezall", OP_REGIMM, rs, RT_BGEZALL, imm)
+}
+
+func Bltzl(rs uint8, imm uint16) IInstruction {
+	return CreateI("bltzl", OP_REGIMM, rs, RT_BLTZL, imm)
+}
+
+func Bltzall(rs uint8, imm uint16) IInstruction {
+	return CreateI("bltzall", OP_REGIMM, rs, RT_BLTZALL, imm)
+}
+
+func Teqi(rs uint8, imm uint16) IInstruction {
+	return CreateI("teqi", OP_REGIMM, rs, RT_TEQI, imm)
+}
+
+func Tnei(rs uint8, imm uint16) IInstruction {
+	return CreateI("tnei", OP_REGIMM, rs, RT_TNEI, imm)
+}
+
+func Tgei(rs uint8, imm uint16) IInstruction {
+	return CreateI("tgei", OP_REGIMM, rs, RT_TGEI, imm)
+}
+
+func Tgeiu(rs uint8, imm uint16) IInstruction {
+	return CreateI("tgeiu", OP_REGIMM, rs, RT_TGEIU, imm)
+}
+
+func Tlti(rs uint8, imm uint16) IInstruction {
+	return CreateI("tlti", OP_REGIMM, rs, RT_TLTI, imm)
+}
+
+func Tltiu(rs uint8, imm uint16) IInstruction {
+	return CreateI("tltiu", OP_REGIMM, rs, RT_TLTIU, imm)
 }
@@ -62,7 +62,7 @@ const (
 
 func Parse(bits uint32) Instruction {
 	opcode := bits >> SHIFT_OPCODE
-	if opcode == OP_SPECIAL || opcode == OP_SPECIAL2 {
+	if opcode == OP_SPECIAL || opcode == OP_SPECIAL2 || opcode == OP_SPECIAL3 || opcode == OP_COP0 {
 		return ParseR(bits)
 	} else {
 		var result Instruction = ParseI(bits)
@@ -83,3 +83,22 @@ func ToBin(instrs []Instruction) []uint32 {
 	}
 	return result
 }
+
+// the releases of MIPS32
+const (
+	MIPS32R1 = 1
+	MIPS32R2 = 2
+)
+
+var release2 = map[string]bool{
+	"ext": true, "ins": true, "seb": true, "seh": true, "wsbh": true,
+	"rotr": true, "rotrv": true, "di": true, "ei": true, "rdhwr": true,
+}
+
+// Revision is the release of MIPS32 which added the instruction of the token, MIPS32R1 for the others
+func Revision(token string) int {
+	if release2[token] {
+		return MIPS32R2
+	}
+	return MIPS32R1
+}
@@ -41,9 +41,55 @@ const (
 	FT_JALR    = 0x09
 	FT_SYSCALL = 0x0c
 	FT_BREAK   = 0x0d
-	FT_MUL     = 0x02
+	FT_MOVZ    = 0x0a
+	FT_MOVN    = 0x0b
+	FT_SYNC    = 0x0f
+	FT_TGE     = 0x30
+	FT_TGEU    = 0x31
+	FT_TLT     = 0x32
+	FT_TLTU    = 0x33
+	FT_TEQ     = 0x34
+	FT_TNE     = 0x36
 )
 
+// the funct field of the SPECIAL3 instructions of MIPS32 release 2, and the shamt field of BSHFL
+const (
+	FT_EXT   = 0x00
+	FT_INS   = 0x04
+	FT_BSHFL = 0x20
+	FT_RDHWR = 0x3b
+
+	BSHFL_WSBH = 0x02
+	BSHFL_SEB  = 0x10
+	BSHFL_SEH  = 0x18
+)
+
+// di and ei are the MFMC0 instructions of coprocessor 0 on the Status register
+const (
+	RS_MFMC0    = 0x0b
+	COP0_STATUS = 12
+	FT_DI       = 0x00
+	FT_EI       = 0x20
+)
+
+// the rs field of rotr and the shamt field of rotrv, which tell them from srl and srlv
+const ROTATE = 0x01
+
+// the funct field of the SPECIAL2 instructions
+const (
+	FT_MADD  = 0x00
+	FT_MADDU = 0x01
+	FT_MUL   = 0x02
+	FT_MSUB  = 0x04
+	FT_MSUBU = 0x05
+	FT_CLZ   = 0x20
+	FT_CLO   = 0x21
+)
+
+func isTrap(funct uint8) bool {
+	return funct == FT_TGE || funct == FT_TGEU || funct == FT_TLT || funct == FT_TLTU || funct == FT_TEQ || funct == FT_TNE
+}
+
 func (this RInstruction) GetToken() string {
 	return this.Token
 }
@@ -61,15 +107,54 @@ func (this RInstruction) ToASM() string {
 			return fmt.Sprintf("%-7s $%d, $%d", this.Token, this.Rd, this.Rs)
 		} else if this.Funct == FT_MULT || this.Funct == FT_MULTU || this.Funct == FT_DIV || this.Funct == FT_DIVU {
 			return fmt.Sprintf("%-7s $%d, $%d", this.Token, this.Rs, this.Rt)
+		} else if this.Funct == FT_SYNC {
+			if this.Shamt == 0 {
+				return fmt.Sprintf("%-7s", this.Token)
+			}
+			return fmt.Sprintf("%-7s %d", this.Token, this.Shamt)
+		} else if this.Funct == FT_SRL && this.Rs == ROTATE {
+			return fmt.Sprintf("%-7s $%d, $%d, %d", this.Token, this.Rd, this.Rt, this.Shamt)
+		} else if this.Funct == FT_SRLV && this.Shamt == ROTATE {
+			return fmt.Sprintf("%-7s $%d, $%d, $%d", this.Token, this.Rd, this.Rt, this.Rs)
+		} else if isTrap(this.Funct) {
+			if code := this.TrapCode(); code != 0 {
+				return fmt.Sprintf("%-7s $%d, $%d, %d", this.Token, this.Rs, this.Rt, code)
+			}
+			return fmt.Sprintf("%-7s $%d, $%d", this.Token, this.Rs, this.Rt)
 		}
 	} else if this.Opcode == OP_SPECIAL2 {
 		if this.Funct == FT_MUL {
 			return fmt.Sprintf("%-7s $%d, $%d, $%d", this.Token, this.Rd, this.Rs, this.Rt)
+		} else if this.Funct == FT_MADD || this.Funct == FT_MADDU || this.Funct == FT_MSUB || this.Funct == FT_MSUBU {
+			return fmt.Sprintf("%-7s $%d, $%d", this.Token, this.Rs, this.Rt)
+		} else if this.Funct == FT_CLZ || this.Funct == FT_CLO {
+			return fmt.Sprintf("%-7s $%d, $%d", this.Token, this.Rd, this.Rs)
 		}
+	} else if this.Opcode == OP_SPECIAL3 {
+		switch this.Funct {
+		case FT_EXT: // rd is size-1 and shamt is pos
+			return fmt.Sprintf("%-7s $%d, $%d, %d, %d", this.Token, this.Rt, this.Rs, this.Shamt, this.Rd+1)
+		case FT_INS: // rd is pos+size-1 and shamt is pos
+			return fmt.Sprintf("%-7s $%d, $%d, %d, %d", this.Token, this.Rt, this.Rs, this.Shamt, int(this.Rd)-int(this.Shamt)+1)
+		case FT_BSHFL:
+			return fmt.Sprintf("%-7s $%d, $%d", this.Token, this.Rd, this.Rt)
+		case FT_RDHWR:
+			return fmt.Sprintf("%-7s $%d, $%d", this.Token, this.Rt, this.Rd)
+		}
+	} else if this.Opcode == OP_COP0 {
+		if this.Rt == 0 {
+			return fmt.Sprintf("%-7s", this.Token)
+		}
+		return fmt.Sprintf("%-7s $%d", this.Token, this.Rt)
 	}
 	return fmt.Sprintf("%-7s $%d, $%d, $%d", this.Token, this.Rd, this.Rs, this.Rt)
 }
 
+// TrapCode is the code of the trap instructions, in the bits 6-15
+func (this RInstruction) TrapCode() uint32 {
+	return uint32(this.Rd)<<5 | uint32(this.Shamt)
+}
+
 func (this RInstruction) ToBits() uint32 {
 	return (uint32(this.Opcode) & MASK_OPCODE << SHIFT_OPCODE) | (uint32(this.Rs) & MASK_REG << SHIFT_RS) | (uint32(this.Rt) & MASK_REG << SHIFT_RT) | (uint32(this.Rd) & MASK_REG << SHIFT_RD) | (uint32(this.Shamt) & MASK_SHAMT << SHIFT_SHAMT) | (uint32(this.Funct) & MASK_FUNCT << SHIFT_FUNCT)
 }
@@ -115,12 +200,18 @@ func ParseR(bits uint32) RInstruction {
 			result.Token = "sll"
 		case FT_SRL:
 			result.Token = "srl"
+			if result.Rs == ROTATE {
+				result.Token = "rotr"
+			}
 		case FT_SRA:
 			result.Token = "sra"
 		case FT_SLLV:
 			result.Token = "sllv"
 		case FT_SRLV:
 			result.Token = "srlv"
+			if result.Shamt == ROTATE {
+				result.Token = "rotrv"
+			}
 		case FT_SRAV:
 			result.Token = "srav"
 		case FT_JR:
@@ -139,11 +230,68 @@ func ParseR(bits uint32) RInstruction {
 			result.Token = "div"
 		case FT_DIVU:
 			result.Token = "divu"
+		case FT_MOVZ:
+			result.Token = "movz"
+		case FT_MOVN:
+			result.Token = "movn"
+		case FT_SYNC:
+			result.Token = "sync"
+		case FT_TGE:
+			result.Token = "tge"
+		case FT_TGEU:
+			result.Token = "tgeu"
+		case FT_TLT:
+			result.Token = "tlt"
+		case FT_TLTU:
+			result.Token = "tltu"
+		case FT_TEQ:
+			result.Token = "teq"
+		case FT_TNE:
+			result.Token = "tne"
 		}
 	case OP_SPECIAL2:
 		switch result.Funct {
 		case FT_MUL:
 			result.Token = "mul"
+		case FT_MADD:
+			result.Token = "madd"
+		case FT_MADDU:
+			result.Token = "maddu"
+		case FT_MSUB:
+			result.Token = "msub"
+		case FT_MSUBU:
+			result.Token = "msubu"
+		case FT_CLZ:
+			result.Token = "clz"
+		case FT_CLO:
+			result.Token = "clo"
+		}
+	case OP_SPECIAL3:
+		switch result.Funct {
+		case FT_EXT:
+			result.Token = "ext"
+		case FT_INS:
+			result.Token = "ins"
+		case FT_RDHWR:
+			result.Token = "rdhwr"
+		case FT_BSHFL:
+			switch result.Shamt {
+			case BSHFL_WSBH:
+				result.Token = "wsbh"
+			case BSHFL_SEB:
+				result.Token = "seb"
+			case BSHFL_SEH:
+				result.Token = "seh"
+			}
+		}
+	case OP_COP0:
+		if result.Rs == RS_MFMC0 && result.Rd == COP0_STATUS && result.Shamt == 0 {
+			switch result.Funct {
+			case FT_DI:
+				result.Token = "di"
+			case FT_EI:
+				result.Token = "ei"
+			}
 		}
 	}
 	return result
@@ -255,7 +403,7 @@ func Mfhi(rd uint8) RInstruction {
 }
 
 func Mthi(rs uint8) RInstruction {
-	return CreateR("mthi", OP_SPECIAL, rs, 0x0, 0x0, 0x0, FT_MFHI)
+	return CreateR("mthi", OP_SPECIAL, rs, 0x0, 0x0, 0x0, FT_MTHI)
 }
 
 func Mflo(rd uint8) RInstruction {
@@ -263,5 +411,115 @@ func Mflo(rd uint8) RInstruction {
 }
 
 func Mtlo(rs uint8) RInstruction {
-	return CreateR("mtlo", OP_SPECIAL, rs, 0x0, 0x0, 0x0, FT_MFLO)
+	return CreateR("mtlo", OP_SPECIAL, rs, 0x0, 0x0, 0x0, FT_MTLO)
+}
+
+func Movz(rd uint8, rs uint8, rt uint8) RInstruction {
+	return CreateR("movz", OP_SPECIAL, rs, rt, rd, 0x0, FT_MOVZ)
+}
+
+func Movn(rd uint8, rs uint8, rt uint8) RInstruction {
+	return CreateR("movn", OP_SPECIAL, rs, rt, rd, 0x0, FT_MOVN)
+}
+
+// the rt field of clz and clo must be the same as rd
+func Clz(rd uint8, rs uint8) RInstruction {
+	return CreateR("clz", OP_SPECIAL2, rs, rd, rd, 0x0, FT_CLZ)
+}
+
+func Clo(rd uint8, rs uint8) RInstruction {
+	return CreateR("clo", OP_SPECIAL2, rs, rd, rd, 0x0, FT_CLO)
+}
+
+func Madd(rs uint8, rt uint8) RInstruction {
+	return CreateR("madd", OP_SPECIAL2, rs, rt, 0x0, 0x0, FT_MADD)
+}
+
+func Maddu(rs uint8, rt uint8) RInstruction {
+	return CreateR("maddu", OP_SPECIAL2, rs, rt, 0x0, 0x0, FT_MADDU)
+}
+
+func Msub(rs uint8, rt uint8) RInstruction {
+	return CreateR("msub", OP_SPECIAL2, rs, rt, 0x0, 0x0, FT_MSUB)
+}
+
+func Msubu(rs uint8, rt uint8) RInstruction {
+	return CreateR("msubu", OP_SPECIAL2, rs, rt, 0x0, 0x0, FT_MSUBU)
+}
+
+func Sync(stype uint8) RInstruction {
+	return CreateR("sync", OP_SPECIAL, 0x0, 0x0, 0x0, stype, FT_SYNC)
+}
+
+func createTrap(token string, rs uint8, rt uint8, code uint32, funct uint8) RInstruction {
+	code &= 0x3ff
+	return CreateR(token, OP_SPECIAL, rs, rt, uint8(code>>5), uint8(code), funct)
+}
+
+func Teq(rs uint8, rt uint8, code uint32) RInstruction {
+	return createTrap("teq", rs, rt, code, FT_TEQ)
+}
+
+func Tne(rs uint8, rt uint8, code uint32) RInstruction {
+	return createTrap("tne", rs, rt, code, FT_TNE)
+}
+
+func Tge(rs uint8, rt uint8, code uint32) RInstruction {
+	return createTrap("tge", rs, rt, code, FT_TGE)
+}
+
+func Tgeu(rs uint8, rt uint8, code uint32) RInstruction {
+	return createTrap("tgeu", rs, rt, code, FT_TGEU)
+}
+
+func Tlt(rs uint8, rt uint8, code uint32) RInstruction {
+	return createTrap("tlt", rs, rt, code, FT_TLT)
+}
+
+func Tltu(rs uint8, rt uint8, code uint32) RInstruction {
+	return createTrap("tltu", rs, rt, code, FT_TLTU)
+}
+
+func Rotr(rd uint8, rt uint8, shamt uint8) RInstruction {
+	return CreateR("rotr", OP_SPECIAL, ROTATE, rt, rd, shamt, FT_SRL)
+}
+
+func Rotrv(rd uint8, rt uint8, rs uint8) RInstruction {
+	return CreateR("rotrv", OP_SPECIAL, rs, rt, rd, ROTATE, FT_SRLV)
+}
+
+// Ext extracts the size bits of rs from pos into rt
+func Ext(rt uint8, rs uint8, pos uint8, size uint8) RInstruction {
+	return CreateR("ext", OP_SPECIAL3, rs, rt, size-1, pos, FT_EXT)
+}
+
+// Ins inserts the low size bits of rs into rt from pos
+func Ins(rt uint8, rs uint8, pos uint8, size uint8) RInstruction {
+	return CreateR("ins", OP_SPECIAL3, rs, rt, pos+size-1, pos, FT_INS)
+}
+
+func Wsbh(rd uint8, rt uint8) RInstruction {
+	return CreateR("wsbh", OP_SPECIAL3, 0x0, rt, rd, BSHFL_WSBH, FT_BSHFL)
+}
+
+func Seb(rd uint8, rt uint8) RInstruction {
+	return CreateR("seb", OP_SPECIAL3, 0x0, rt, rd, BSHFL_SEB, FT_BSHFL)
+}
+
+func Seh(rd uint8, rt uint8) RInstruction {
+	return CreateR("seh", OP_SPECIAL3, 0x0, rt, rd, BSHFL_SEH, FT_BSHFL)
+}
+
+// Rdhwr reads the hardware register rd into rt
+func Rdhwr(rt uint8, rd uint8) RInstruction {
+	return CreateR("rdhwr", OP_SPECIAL3, 0x0, rt, rd, 0x0, FT_RDHWR)
+}
+
+// Di clears the interrupt enable bit of Status, which is read into rt before
+func Di(rt uint8) RInstruction {
+	return CreateR("di", OP_COP0, RS_MFMC0, rt, COP0_STATUS, 0x0, FT_DI)
+}
+
+func Ei(rt uint8) RInstruction {
+	return CreateR("ei", OP_COP0, RS_MFMC0, rt, COP0_STATUS, 0x0, FT_EI)
 }
@@ -0,0 +1,62 @@
+package memfile
+
+import (
+	"fmt"
+)
+
+var mifRadixes = map[int]string{2: "BIN", 8: "OCT", 10: "DEC", 16: "HEX"}
+
+// ToMIF writes the Quartus memory initialization file
+func ToMIF(image []uint8, opt Options) ([]string, error) {
+	words, err := Words(image, opt)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(words)+6)
+	result = append(result, fmt.Sprintf("WIDTH=%d;", opt.Width))
+	result = append(result, fmt.Sprintf("DEPTH=%d;", len(words)))
+	result = append(result, fmt.Sprintf("ADDRESS_RADIX=%s;", mifRadixes[opt.AddressRadix]))
+	result = append(result, "DATA_RADIX=HEX;")
+	result = append(result, "CONTENT BEGIN")
+	for i, word := range words {
+		result = append(result, fmt.Sprintf("    %s : %s;", formatAddress(i, len(words), opt.AddressRadix), formatWord(word, opt.Width, 16)))
+	}
+	result = append(result, "END;")
+	return result, nil
+}
+
+// ToCOE writes the Xilinx coefficient file for the block memory generator, which has no addresses
+func ToCOE(image []uint8, opt Options) ([]string, error) {
+	words, err := Words(image, opt)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(words)+2)
+	result = append(result, "memory_initialization_radix=16;")
+	result = append(result, "memory_initialization_vector=")
+	for i, word := range words {
+		end := ","
+		if i == len(words)-1 {
+			end = ";"
+		}
+		result = append(result, formatWord(word, opt.Width, 16)+end)
+	}
+	return result, nil
+}
+
+// ToReadmem writes the file for $readmemh if radix is 16 or $readmemb if radix is 2,
+// one word per line with the address of every line in a comment
+func ToReadmem(image []uint8, opt Options, radix int) ([]string, error) {
+	if radix != 2 && radix != 16 {
+		return nil, fmt.Errorf("invalid data radix %d of $readmem, expect 2 or 16", radix)
+	}
+	words, err := Words(image, opt)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(words))
+	for i, word := range words {
+		result = append(result, fmt.Sprintf("%s // %s", formatWord(word, opt.Width, radix), formatAddress(i, len(words), opt.AddressRadix)))
+	}
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package memfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// the record types of Intel HEX
+const (
+	IHEX_DATA          = 0x00
+	IHEX_EOF           = 0x01
+	IHEX_SEGMENT       = 0x02 // extended segment address
+	IHEX_START_SEGMENT = 0x03
+	IHEX_LINEAR        = 0x04 // extended linear address
+	IHEX_START_LINEAR  = 0x05
+)
+
+func ihexRecord(kind uint8, addr uint16, data []uint8) string {
+	bytes := append([]uint8{uint8(len(data)), uint8(addr >> 8), uint8(addr), kind}, data...)
+	sum := uint8(0)
+	for _, b := range bytes {
+		sum += b
+	}
+	return fmt.Sprintf(":%X%02X", bytes, uint8(-sum))
+}
+
+// ToIntelHex writes the image as Intel HEX records, with an extended linear address record
+// whenever the upper 16 bits of the address change, and the start linear address for the entry
+func ToIntelHex(image Image) ([]string, error) {
+	if err := image.check(); err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(image.Data)/RECORD_SIZE+4)
+	upper := uint32(0)
+	for _, run := range image.runs() {
+		for i := 0; i < len(run.Data); {
+			addr := run.Base + uint32(i)
+			if addr>>16 != upper {
+				upper = addr >> 16
+				result = append(result, ihexRecord(IHEX_LINEAR, 0, []uint8{uint8(upper >> 8), uint8(upper)}))
+			}
+			// no record crosses a 64 KiB boundary
+			size := RECORD_SIZE
+			if limit := 0x10000 - int(addr&0xffff); size > limit {
+				size = limit
+			}
+			if size > len(run.Data)-i {
+				size = len(run.Data) - i
+			}
+			result = append(result, ihexRecord(IHEX_DATA, uint16(addr), run.Data[i:i+size]))
+			i += size
+		}
+	}
+	if image.HasEntry {
+		entry := make([]uint8, 4)
+		binary.BigEndian.PutUint32(entry, image.Entry)
+		result = append(result, ihexRecord(IHEX_START_LINEAR, 0, entry))
+	}
+	result = append(result, ihexRecord(IHEX_EOF, 0, nil))
+	return result, nil
+}
+
+// ReadIntelHex reads the records of Intel HEX, both the segment and the linear addresses
+func ReadIntelHex(content []string) (Image, error) {
+	var records recordImage
+	offset, ended := uint64(0), false
+	err := recordLines(content, func(line string, number int) error {
+		if ended {
+			return fmt.Errorf("line %d: record after the end of file", number)
+		}
+		if !strings.HasPrefix(line, ":") {
+			return fmt.Errorf("line %d: expect a record starting with ':'", number)
+		}
+		bytes, err := recordBytes(line[1:], number)
+		if err != nil {
+			return err
+		}
+		if len(bytes) < 5 || len(bytes) != int(bytes[0])+5 {
+			return fmt.Errorf("line %d: invalid record length", number)
+		}
+		sum := uint8(0)
+		for _, b := range bytes {
+			sum += b
+		}
+		if sum != 0 {
+			return fmt.Errorf("line %d: checksum mismatch", number)
+		}
+		addr, kind, data := uint64(binary.BigEndian.Uint16(bytes[1:3])), bytes[3], bytes[4:len(bytes)-1]
+		expect := func(size int) error {
+			if len(data) != size {
+				return fmt.Errorf("line %d: expect %d bytes in the record of type %02X", number, size, kind)
+			}
+			return nil
+		}
+		switch kind {
+		case IHEX_DATA:
+			return records.add(offset+addr, data, number)
+		case IHEX_EOF:
+			ended = true
+		case IHEX_SEGMENT:
+			if err := expect(2); err != nil {
+				return err
+			}
+			offset = uint64(binary.BigEndian.Uint16(data)) << 4
+		case IHEX_LINEAR:
+			if err := expect(2); err != nil {
+				return err
+			}
+			offset = uint64(binary.BigEndian.Uint16(data)) << 16
+		case IHEX_START_SEGMENT:
+			if err := expect(4); err != nil {
+				return err
+			}
+			records.result.Entry = uint32(binary.BigEndian.Uint16(data))<<4 + uint32(binary.BigEndian.Uint16(data[2:]))
+			records.result.HasEntry = true
+		case IHEX_START_LINEAR:
+			if err := expect(4); err != nil {
+				return err
+			}
+			records.result.Entry = binary.BigEndian.Uint32(data)
+			records.result.HasEntry = true
+		default:
+			return fmt.Errorf("line %d: unknown record type %02X", number, kind)
+		}
+		return nil
+	})
+	if err != nil {
+		return Image{}, err
+	}
+	if !ended {
+		return Image{}, fmt.Errorf("no end of file record")
+	}
+	return records.image(), nil
+}
@@ -0,0 +1,155 @@
+package memfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the headers of the Logisim memory image files
+const (
+	LOGISIM_RAW       = "v2.0 raw"
+	LOGISIM_PLAIN     = "v3.0 hex words plain"
+	LOGISIM_ADDRESSED = "v3.0 hex words addressed"
+)
+
+// the words of a line of the Logisim files written, and the most words read without a depth
+const (
+	LOGISIM_LINE      = 8
+	LOGISIM_MAX_WORDS = 1 << 24
+)
+
+// a run of the same word, written as count*value if count is more than 1
+type logisimRun struct {
+	count int
+	value uint32
+}
+
+func (this logisimRun) String() string {
+	if this.count == 1 {
+		return strconv.FormatUint(uint64(this.value), 16)
+	}
+	return fmt.Sprintf("%d*%s", this.count, strconv.FormatUint(uint64(this.value), 16))
+}
+
+func logisimRuns(words []uint32) []logisimRun {
+	result := make([]logisimRun, 0)
+	for _, word := range words {
+		if n := len(result); n > 0 && result[n-1].value == word {
+			result[n-1].count++
+		} else {
+			result = append(result, logisimRun{1, word})
+		}
+	}
+	return result
+}
+
+// ToLogisim writes the words of the image for the ROM and RAM components of Logisim:
+// "v2.0 raw" if version is 2, or "v3.0 hex words addressed" of Logisim-evolution if it is 3,
+// the same words in a row are written as count*value
+func ToLogisim(image []uint8, opt Options, version int) ([]string, error) {
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("invalid Logisim image version %d, expect 2 or 3", version)
+	}
+	words, err := Words(image, opt)
+	if err != nil {
+		return nil, err
+	}
+	runs := logisimRuns(words)
+	result := make([]string, 0, len(runs)/LOGISIM_LINE+2)
+	if version == 2 {
+		result = append(result, LOGISIM_RAW)
+	} else {
+		result = append(result, LOGISIM_ADDRESSED)
+	}
+	addr := 0
+	for i := 0; i < len(runs); i += LOGISIM_LINE {
+		tokens := make([]string, 0, LOGISIM_LINE+1)
+		if version == 3 {
+			tokens = append(tokens, formatAddress(addr, len(words), 16)+":")
+		}
+		end := i + LOGISIM_LINE
+		if end > len(runs) {
+			end = len(runs)
+		}
+		for _, run := range runs[i:end] {
+			tokens = append(tokens, run.String())
+			addr += run.count
+		}
+		result = append(result, strings.Join(tokens, " "))
+	}
+	return result, nil
+}
+
+// FromLogisim reads the words of the Logisim image files, "v2.0 raw" or the "v3.0 hex words" ones,
+// into the bytes of the image from word 0
+func FromLogisim(content []string, opt Options) ([]uint8, error) {
+	limit := uint64(LOGISIM_MAX_WORDS)
+	if opt.Depth > 0 {
+		limit = uint64(opt.Depth)
+	}
+	words := make([]uint32, 0)
+	header, addressed := false, false
+	for i, line := range content {
+		if ind := strings.Index(line, "#"); ind != -1 {
+			line = line[:ind]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !header {
+			switch line {
+			case LOGISIM_RAW, LOGISIM_PLAIN:
+			case LOGISIM_ADDRESSED:
+				addressed = true
+			default:
+				return nil, fmt.Errorf("line %d: unknown Logisim image header %s, expect %s, %s or %s", i+1, line, LOGISIM_RAW, LOGISIM_PLAIN, LOGISIM_ADDRESSED)
+			}
+			header = true
+			continue
+		}
+		if addressed {
+			ind := strings.Index(line, ":")
+			if ind == -1 {
+				return nil, fmt.Errorf("line %d: expect the address before ':'", i+1)
+			}
+			addr, err := strconv.ParseUint(strings.TrimSpace(line[:ind]), 16, 32)
+			if err != nil || addr < uint64(len(words)) {
+				return nil, fmt.Errorf("line %d: invalid address %s", i+1, strings.TrimSpace(line[:ind]))
+			}
+			if addr > limit {
+				return nil, fmt.Errorf("line %d: more than %d words", i+1, limit)
+			}
+			for uint64(len(words)) < addr {
+				words = append(words, 0)
+			}
+			line = line[ind+1:]
+		}
+		for _, token := range strings.Fields(line) {
+			count, value := uint64(1), token
+			if ind := strings.Index(token, "*"); ind != -1 {
+				var err error
+				count, err = strconv.ParseUint(token[:ind], 10, 32)
+				if err != nil || count == 0 {
+					return nil, fmt.Errorf("line %d: invalid count of %s", i+1, token)
+				}
+				value = token[ind+1:]
+			}
+			word, err := strconv.ParseUint(value, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid word %s", i+1, token)
+			}
+			if uint64(len(words))+count > limit {
+				return nil, fmt.Errorf("line %d: more than %d words", i+1, limit)
+			}
+			for j := uint64(0); j < count; j++ {
+				words = append(words, uint32(word))
+			}
+		}
+	}
+	if !header {
+		return nil, fmt.Errorf("no Logisim image header")
+	}
+	return Bytes(words, opt)
+}
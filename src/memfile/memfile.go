@@ -0,0 +1,112 @@
+package memfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Options is how a byte image is written as the words of a memory
+type Options struct {
+	Width        int  // bits of a word: 8, 16 or 32
+	Depth        int  // words of the memory, 0 for as many as the image needs
+	BigEndian    bool // the first byte of a word is the most significant one
+	AddressRadix int  // of the addresses in the file: 2, 8, 10 or 16
+}
+
+// NewOptions gives the options of a memory of the width, with the other ones by default
+func NewOptions(width int) Options {
+	return Options{Width: width, AddressRadix: 16}
+}
+
+func (this Options) check() error {
+	switch this.Width {
+	case 8, 16, 32:
+	default:
+		return fmt.Errorf("invalid word width %d, expect 8, 16 or 32", this.Width)
+	}
+	switch this.AddressRadix {
+	case 2, 8, 10, 16:
+	default:
+		return fmt.Errorf("invalid address radix %d, expect 2, 8, 10 or 16", this.AddressRadix)
+	}
+	if this.Depth < 0 {
+		return fmt.Errorf("invalid depth %d", this.Depth)
+	}
+	return nil
+}
+
+// Words groups the bytes of the image into the words of the memory, padded with 0 up to the depth
+func Words(image []uint8, opt Options) ([]uint32, error) {
+	if err := opt.check(); err != nil {
+		return nil, err
+	}
+	size := opt.Width / 8
+	count := (len(image) + size - 1) / size
+	depth := opt.Depth
+	if depth == 0 {
+		depth = count
+	} else if count > depth {
+		return nil, fmt.Errorf("the image of %d words is deeper than the memory of %d words", count, depth)
+	}
+	result := make([]uint32, depth)
+	for i := 0; i < count; i++ {
+		word := uint32(0)
+		for j := 0; j < size; j++ {
+			b := uint32(0)
+			if i*size+j < len(image) {
+				b = uint32(image[i*size+j])
+			}
+			if opt.BigEndian {
+				word = word<<8 | b
+			} else {
+				word |= b << uint(8*j)
+			}
+		}
+		result[i] = word
+	}
+	return result, nil
+}
+
+// Bytes splits the words of the memory back into the bytes of the image, the inverse of Words
+func Bytes(words []uint32, opt Options) ([]uint8, error) {
+	if err := opt.check(); err != nil {
+		return nil, err
+	}
+	size := opt.Width / 8
+	result := make([]uint8, 0, len(words)*size)
+	for _, word := range words {
+		if opt.Width < 32 && word>>uint(opt.Width) != 0 {
+			return nil, fmt.Errorf("word %X is wider than %d bits", word, opt.Width)
+		}
+		for j := 0; j < size; j++ {
+			shift := uint(8 * j)
+			if opt.BigEndian {
+				shift = uint(8 * (size - 1 - j))
+			}
+			result = append(result, uint8(word>>shift))
+		}
+	}
+	return result, nil
+}
+
+// format the word with all the digits of the width in the radix
+func formatWord(word uint32, width int, radix int) string {
+	digits := len(strconv.FormatUint(uint64(1)<<uint(width)-1, radix))
+	str := strings.ToUpper(strconv.FormatUint(uint64(word), radix))
+	return strings.Repeat("0", digits-len(str)) + str
+}
+
+// format the address with the digits of the last address of the depth
+func formatAddress(addr int, depth int, radix int) string {
+	last := depth - 1
+	if last < 0 {
+		last = 0
+	}
+	digits := len(strconv.FormatUint(uint64(last), radix))
+	str := strings.ToUpper(strconv.FormatUint(uint64(addr), radix))
+	if len(str) < digits {
+		str = strings.Repeat("0", digits-len(str)) + str
+	}
+	return str
+}
@@ -0,0 +1,109 @@
+package memfile
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Image is the bytes of a memory from its base address, with the entry point if it has one,
+// as the record formats carry the addresses
+type Image struct {
+	Base     uint32
+	Data     []uint8
+	Entry    uint32
+	HasEntry bool
+	// the parts of the memory with content, like the sections, the record formats write
+	// only them and skip the gaps, or all of Data if it is nil
+	Parts []Image
+}
+
+// Flat gives the bytes of the image from address 0, for the simulator
+func (this Image) Flat() []uint8 {
+	result := make([]uint8, int(this.Base)+len(this.Data))
+	copy(result[this.Base:], this.Data)
+	return result
+}
+
+// the bytes of a record each of the formats puts in one line
+const RECORD_SIZE = 16
+
+// the ranges the record formats write
+func (this Image) runs() []Image {
+	if this.Parts != nil {
+		return this.Parts
+	}
+	return []Image{this}
+}
+
+func (this Image) check() error {
+	for _, run := range this.runs() {
+		if uint64(run.Base)+uint64(len(run.Data)) > 1<<32 {
+			return fmt.Errorf("the image [0x%08x, 0x%x) is out of the 32-bit address space", run.Base, uint64(run.Base)+uint64(len(run.Data)))
+		}
+	}
+	return nil
+}
+
+// parse the hexadecimal bytes of a record of the line
+func recordBytes(digits string, line int) ([]uint8, error) {
+	if len(digits)%2 != 0 {
+		return nil, fmt.Errorf("line %d: odd number of hex digits", line)
+	}
+	result, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: invalid hex digits", line)
+	}
+	return result, nil
+}
+
+// collect the records of data as an image, from the lowest address up to the highest one, the gaps are 0
+type recordImage struct {
+	chunks []Image
+	result Image
+}
+
+func (this *recordImage) add(addr uint64, data []uint8, line int) error {
+	if addr+uint64(len(data)) > 1<<32 {
+		return fmt.Errorf("line %d: the data at 0x%x is out of the 32-bit address space", line, addr)
+	}
+	if len(data) > 0 {
+		this.chunks = append(this.chunks, Image{Base: uint32(addr), Data: data})
+	}
+	return nil
+}
+
+func (this *recordImage) image() Image {
+	if len(this.chunks) == 0 {
+		return this.result
+	}
+	low, high := uint64(1)<<32, uint64(0)
+	for _, chunk := range this.chunks {
+		if uint64(chunk.Base) < low {
+			low = uint64(chunk.Base)
+		}
+		if end := uint64(chunk.Base) + uint64(len(chunk.Data)); end > high {
+			high = end
+		}
+	}
+	this.result.Base = uint32(low)
+	this.result.Data = make([]uint8, high-low)
+	for _, chunk := range this.chunks {
+		copy(this.result.Data[uint64(chunk.Base)-low:], chunk.Data)
+	}
+	return this.result
+}
+
+// the lines of the file without the blank ones, with their line numbers
+func recordLines(content []string, each func(line string, number int) error) error {
+	for i, line := range content {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := each(line, i+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
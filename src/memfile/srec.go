@@ -0,0 +1,109 @@
+package memfile
+
+import (
+	"fmt"
+)
+
+// the header of the S-record files written
+const SREC_HEADER = "mip"
+
+func srecRecord(kind byte, addr uint32, addrSize int, data []uint8) string {
+	bytes := []uint8{uint8(addrSize + len(data) + 1)}
+	for i := addrSize - 1; i >= 0; i-- {
+		bytes = append(bytes, uint8(addr>>uint(8*i)))
+	}
+	bytes = append(bytes, data...)
+	sum := uint8(0)
+	for _, b := range bytes {
+		sum += b
+	}
+	return fmt.Sprintf("S%c%X%02X", kind, bytes, ^sum)
+}
+
+// ToSRecord writes the image as Motorola S-records: S1, S2 or S3 by the highest address
+// with the S9, S8 or S7 of the entry, and an S5 or S6 of the count
+func ToSRecord(image Image) ([]string, error) {
+	if err := image.check(); err != nil {
+		return nil, err
+	}
+	// the data record, the termination record and the size of the address
+	end := uint64(0)
+	for _, run := range image.runs() {
+		if runEnd := uint64(run.Base) + uint64(len(run.Data)); runEnd > end {
+			end = runEnd
+		}
+	}
+	data, term, addrSize := byte('1'), byte('9'), 2
+	if end > 1<<24 || image.Entry >= 1<<24 {
+		data, term, addrSize = '3', '7', 4
+	} else if end > 1<<16 || image.Entry >= 1<<16 {
+		data, term, addrSize = '2', '8', 3
+	}
+	result := make([]string, 0, len(image.Data)/RECORD_SIZE+3)
+	result = append(result, srecRecord('0', 0, 2, []uint8(SREC_HEADER)))
+	count := 0
+	for _, run := range image.runs() {
+		for i := 0; i < len(run.Data); i += RECORD_SIZE {
+			end := i + RECORD_SIZE
+			if end > len(run.Data) {
+				end = len(run.Data)
+			}
+			result = append(result, srecRecord(data, run.Base+uint32(i), addrSize, run.Data[i:end]))
+			count++
+		}
+	}
+	if count < 1<<16 {
+		result = append(result, srecRecord('5', uint32(count), 2, nil))
+	} else if count < 1<<24 {
+		result = append(result, srecRecord('6', uint32(count), 3, nil))
+	}
+	result = append(result, srecRecord(term, image.Entry, addrSize, nil))
+	return result, nil
+}
+
+// ReadSRecord reads the Motorola S-records, the entry is the one of the termination record
+func ReadSRecord(content []string) (Image, error) {
+	var records recordImage
+	err := recordLines(content, func(line string, number int) error {
+		if len(line) < 2 || line[0] != 'S' && line[0] != 's' {
+			return fmt.Errorf("line %d: expect a record starting with 'S'", number)
+		}
+		bytes, err := recordBytes(line[2:], number)
+		if err != nil {
+			return err
+		}
+		if len(bytes) < 1 || len(bytes) != int(bytes[0])+1 {
+			return fmt.Errorf("line %d: invalid record length", number)
+		}
+		sum := uint8(0)
+		for _, b := range bytes[:len(bytes)-1] {
+			sum += b
+		}
+		if ^sum != bytes[len(bytes)-1] {
+			return fmt.Errorf("line %d: checksum mismatch", number)
+		}
+		addrSize := map[byte]int{'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2}[line[1]]
+		if addrSize == 0 {
+			return fmt.Errorf("line %d: unknown record type S%c", number, line[1])
+		}
+		if len(bytes) < addrSize+2 {
+			return fmt.Errorf("line %d: the record is too short for its address", number)
+		}
+		addr := uint32(0)
+		for _, b := range bytes[1 : addrSize+1] {
+			addr = addr<<8 | uint32(b)
+		}
+		data := bytes[addrSize+1 : len(bytes)-1]
+		switch line[1] {
+		case '1', '2', '3':
+			return records.add(uint64(addr), data, number)
+		case '7', '8', '9':
+			records.result.Entry, records.result.HasEntry = addr, true
+		}
+		return nil
+	})
+	if err != nil {
+		return Image{}, err
+	}
+	return records.image(), nil
+}
@@ -11,11 +11,20 @@ var PC uint32
 
 var hi, lo uint32
 
+// the Status register of coprocessor 0, only the interrupt enable bit is used
+var status uint32
+
+const STATUS_IE = 0x1
+
 func SetAcc(val uint64) {
     hi = uint32(val >> 32)
     lo = uint32(val & 0xffffffff)
 }
 
+func GetAcc() uint64 {
+    return uint64(hi)<<32 | uint64(lo)
+}
+
 func GetHI() uint32 {
     return hi
 }
@@ -32,6 +41,14 @@ func SetLO(val uint32){
     lo=val
 }
 
+func GetStatus() uint32 {
+    return status
+}
+
+func SetStatus(val uint32) {
+    status = val
+}
+
 func SetGPR(id uint8, val uint32) {
     if !(0 <= id && id < 32) {
         panic(errors.New(fmt.Sprintf("Register set failed %d", id)))
@@ -79,3 +79,27 @@ func mthi(it rinstr) {
 func mtlo(it rinstr) {
 	cpu.SetLO(cpu.GetGPR(it.Rs))
 }
+
+func madd(it rinstr) {
+	x := int64(signext64(cpu.GetGPR(it.Rs)))
+	y := int64(signext64(cpu.GetGPR(it.Rt)))
+	cpu.SetAcc(cpu.GetAcc() + uint64(x*y))
+}
+
+func maddu(it rinstr) {
+	x := uint64(cpu.GetGPR(it.Rs))
+	y := uint64(cpu.GetGPR(it.Rt))
+	cpu.SetAcc(cpu.GetAcc() + x*y)
+}
+
+func msub(it rinstr) {
+	x := int64(signext64(cpu.GetGPR(it.Rs)))
+	y := int64(signext64(cpu.GetGPR(it.Rt)))
+	cpu.SetAcc(cpu.GetAcc() - uint64(x*y))
+}
+
+func msubu(it rinstr) {
+	x := uint64(cpu.GetGPR(it.Rs))
+	y := uint64(cpu.GetGPR(it.Rt))
+	cpu.SetAcc(cpu.GetAcc() - x*y)
+}
@@ -29,9 +29,21 @@ func sltu(it rinstr) {
 }
 
 func sltiu(it iinstr) {
-    if cpu.GetGPR(it.Rs) < uint32(it.Imm) {
+    if cpu.GetGPR(it.Rs) < signext16(it.Imm) {
         cpu.SetGPR(it.Rt, 1)
     } else {
         cpu.SetGPR(it.Rt, 0)
     }
 }
+
+func movn(it rinstr) {
+    if cpu.GetGPR(it.Rt) != 0 {
+        cpu.SetGPR(it.Rd, cpu.GetGPR(it.Rs))
+    }
+}
+
+func movz(it rinstr) {
+    if cpu.GetGPR(it.Rt) == 0 {
+        cpu.SetGPR(it.Rd, cpu.GetGPR(it.Rs))
+    }
+}
@@ -6,56 +6,56 @@ import (
 
 func beq(it iinstr) {
     if cpu.GetGPR(it.Rs) == cpu.GetGPR(it.Rt) {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func bne(it iinstr) {
     if cpu.GetGPR(it.Rs) != cpu.GetGPR(it.Rt) {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func bgez(it iinstr) {
     if int32(cpu.GetGPR(it.Rs)) >= 0 {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func bgezal(it iinstr) {
     beforeCall()
     if int32(cpu.GetGPR(it.Rs)) >= 0 {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func bgtz(it iinstr) {
     if int32(cpu.GetGPR(it.Rs)) > 0 {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func blez(it iinstr) {
     if int32(cpu.GetGPR(it.Rs)) <= 0 {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func bltz(it iinstr) {
     if int32(cpu.GetGPR(it.Rs)) < 0 {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func bltzal(it iinstr) {
     beforeCall()
     if int32(cpu.GetGPR(it.Rs)) < 0 {
-        jumpOneDelay(npc + (signext16(it.Imm) << 2))
+        jump(npc + (signext16(it.Imm) << 2))
     }
 }
 
 func j(it jinstr){
-    jumpOneDelay((cpu.PC & 0xf0000000) | (it.Imm << 2))
+    jump((cpu.PC & 0xf0000000) | (it.Imm << 2))
 }
 
 func jal(it jinstr){
@@ -64,11 +64,54 @@ func jal(it jinstr){
 }
 
 func jr(it rinstr){
-    jumpOneDelay(cpu.GetGPR(it.Rs))
+    jump(cpu.GetGPR(it.Rs))
 }
 
 func jalr(it rinstr){
     tmp := cpu.GetGPR(it.Rs)
     beforeCallSet(it.Rd)
-    jumpOneDelay(tmp)
-}
\ No newline at end of file
+    jump(tmp)
+}
+
+// branchLikely runs the delay slot only if the branch is taken
+func branchLikely(taken bool, offset uint16) {
+    if taken {
+        jump(npc + (signext16(offset) << 2))
+    } else if !config.NoDelaySlot {
+        jumpNoDelay(npc + 4)
+    }
+}
+
+func beql(it iinstr) {
+    branchLikely(cpu.GetGPR(it.Rs) == cpu.GetGPR(it.Rt), it.Imm)
+}
+
+func bnel(it iinstr) {
+    branchLikely(cpu.GetGPR(it.Rs) != cpu.GetGPR(it.Rt), it.Imm)
+}
+
+func bgezl(it iinstr) {
+    branchLikely(int32(cpu.GetGPR(it.Rs)) >= 0, it.Imm)
+}
+
+func bgezall(it iinstr) {
+    beforeCall()
+    bgezl(it)
+}
+
+func bgtzl(it iinstr) {
+    branchLikely(int32(cpu.GetGPR(it.Rs)) > 0, it.Imm)
+}
+
+func blezl(it iinstr) {
+    branchLikely(int32(cpu.GetGPR(it.Rs)) <= 0, it.Imm)
+}
+
+func bltzl(it iinstr) {
+    branchLikely(int32(cpu.GetGPR(it.Rs)) < 0, it.Imm)
+}
+
+func bltzall(it iinstr) {
+    beforeCall()
+    bltzl(it)
+}
@@ -31,6 +31,31 @@ var IsDebug bool
 
 var breakHandler, syscallHandler SignalHandler
 
+// Config is how the branches, the jumps and the loads take effect
+type Config struct {
+	// run the branches and the jumps at once, like MARS, instead of after the delay slot
+	NoDelaySlot bool
+	// the value of a load is only seen from the second instruction after it, like MIPS I
+	LoadDelay bool
+	// the release of MIPS32 whose instructions are run, instruction.MIPS32R1 if 0
+	ISARevision int
+}
+
+var config Config
+
+// a load waiting for its delay slot
+type delayedLoad struct {
+	active bool
+	reg    uint8
+	val    uint32
+}
+
+// the load of the instruction just executed, and the one of the instruction before it
+var loaded, pending delayedLoad
+
+// the count of the instructions executed, the cycle counter of rdhwr
+var cycles uint32
+
 const (
 	MEMU_INITIALIZED = uint32(iota)
 	MEMU_RUNNING
@@ -49,12 +74,69 @@ func jumpOneDelay(addr uint32) {
 	jumped = true
 }
 
+func jumpNoDelay(addr uint32) {
+	cpu.PC = addr
+	npc = addr + 4
+	jumped = true
+}
+
+func jump(addr uint32) {
+	if config.NoDelaySlot {
+		jumpNoDelay(addr)
+	} else {
+		jumpOneDelay(addr)
+	}
+}
+
+// the return address, after the delay slot if there is one
+func linkAddress() uint32 {
+	if config.NoDelaySlot {
+		return npc
+	}
+	return npc + 4
+}
+
 func beforeCall() {
-	cpu.SetGPR(instruction.GPR_RA, npc+4)
+	cpu.SetGPR(instruction.GPR_RA, linkAddress())
 }
 
 func beforeCallSet(rd uint8) {
-	cpu.SetGPR(rd, npc+4)
+	cpu.SetGPR(rd, linkAddress())
+}
+
+// setLoaded writes the register loaded, after the next instruction in the load delay mode
+func setLoaded(reg uint8, val uint32) {
+	if config.LoadDelay {
+		loaded = delayedLoad{true, reg, val}
+	} else {
+		cpu.SetGPR(reg, val)
+	}
+}
+
+// the value of the register merged by lwl and lwr, which may still be in the delay slot of the one before
+func mergedGPR(reg uint8) uint32 {
+	if pending.active && pending.reg == reg {
+		return pending.val
+	}
+	return cpu.GetGPR(reg)
+}
+
+// RetireLoads writes the registers of the loads still waiting, when the execution stops
+func RetireLoads() {
+	for _, load := range []delayedLoad{pending, loaded} {
+		if load.active {
+			cpu.SetGPR(load.reg, load.val)
+		}
+	}
+	pending, loaded = delayedLoad{}, delayedLoad{}
+}
+
+// Configure sets how the branches, the jumps and the loads take effect
+func Configure(val Config) {
+	if val.ISARevision == 0 {
+		val.ISARevision = instruction.MIPS32R1
+	}
+	config = val
 }
 
 func signext(ori uint32, len uint8) uint32 {
@@ -83,6 +165,11 @@ func signext64(ori uint32) uint64 {
 }
 
 func UpdatePC() {
+	if pending.active {
+		cpu.SetGPR(pending.reg, pending.val)
+	}
+	pending, loaded = loaded, delayedLoad{}
+	cycles++
 	if !jumped {
 		advancePC(4)
 	} else {
@@ -147,6 +234,57 @@ func InitializeTable(breakH SignalHandler, syscallH SignalHandler) {
 		"jalr":    ExecRFunc(jalr),
 		"syscall": ExecRFunc(syscall),
 		"break":   ExecRFunc(_break),
+		"lwl":     ExecIFunc(lwl),
+		"lwr":     ExecIFunc(lwr),
+		"swl":     ExecIFunc(swl),
+		"swr":     ExecIFunc(swr),
+		"movn":    ExecRFunc(movn),
+		"movz":    ExecRFunc(movz),
+		"clo":     ExecRFunc(clo),
+		"clz":     ExecRFunc(clz),
+		"madd":    ExecRFunc(madd),
+		"maddu":   ExecRFunc(maddu),
+		"msub":    ExecRFunc(msub),
+		"msubu":   ExecRFunc(msubu),
+		"teq":     ExecRFunc(teq),
+		"tne":     ExecRFunc(tne),
+		"tge":     ExecRFunc(tge),
+		"tgeu":    ExecRFunc(tgeu),
+		"tlt":     ExecRFunc(tlt),
+		"tltu":    ExecRFunc(tltu),
+		"teqi":    ExecIFunc(teqi),
+		"tnei":    ExecIFunc(tnei),
+		"tgei":    ExecIFunc(tgei),
+		"tgeiu":   ExecIFunc(tgeiu),
+		"tlti":    ExecIFunc(tlti),
+		"tltiu":   ExecIFunc(tltiu),
+		"sync":    ExecRFunc(sync),
+		"pref":    ExecIFunc(pref),
+		"cache":   ExecIFunc(cache),
+		"beql":    ExecIFunc(beql),
+		"bnel":    ExecIFunc(bnel),
+		"bgezl":   ExecIFunc(bgezl),
+		"bgezall": ExecIFunc(bgezall),
+		"bgtzl":   ExecIFunc(bgtzl),
+		"blezl":   ExecIFunc(blezl),
+		"bltzl":   ExecIFunc(bltzl),
+		"bltzall": ExecIFunc(bltzall),
+		"rotr":    ExecRFunc(rotr),
+		"rotrv":   ExecRFunc(rotrv),
+		"ext":     ExecRFunc(ext),
+		"ins":     ExecRFunc(ins),
+		"seb":     ExecRFunc(seb),
+		"seh":     ExecRFunc(seh),
+		"wsbh":    ExecRFunc(wsbh),
+		"rdhwr":   ExecRFunc(rdhwr),
+		"di":      ExecRFunc(di),
+		"ei":      ExecRFunc(ei),
+	}
+	// the instructions of the later releases are unknown to the earlier ones
+	for token := range ExecTable {
+		if rev := instruction.Revision(token); rev > instruction.MIPS32R1 && rev > config.ISARevision {
+			delete(ExecTable, token)
+		}
 	}
 	breakHandler = breakH
 	syscallHandler = syscallH
@@ -154,6 +292,8 @@ func InitializeTable(breakH SignalHandler, syscallH SignalHandler) {
 
 func InitializePC(pc uint32) {
 	jumped = false
+	pending, loaded = delayedLoad{}, delayedLoad{}
+	cycles = 0
 	cpu.PC = pc
 	npc = pc + 4
 }
@@ -1,6 +1,8 @@
 package exec
 
 import (
+    "math/bits"
+
     "../cpu"
 )
 
@@ -31,3 +33,41 @@ func xor(it rinstr) {
 func xori(it iinstr) {
     cpu.SetGPR(it.Rt, cpu.GetGPR(it.Rs)^uint32(it.Imm))
 }
+
+func clo(it rinstr) {
+    cpu.SetGPR(it.Rd, uint32(bits.LeadingZeros32(^cpu.GetGPR(it.Rs))))
+}
+
+func clz(it rinstr) {
+    cpu.SetGPR(it.Rd, uint32(bits.LeadingZeros32(cpu.GetGPR(it.Rs))))
+}
+
+// the low size bits set
+func fieldMask(size uint32) uint32 {
+    return uint32(uint64(1)<<size - 1)
+}
+
+// ext: rd is size-1 and shamt is pos
+func ext(it rinstr) {
+    cpu.SetGPR(it.Rt, cpu.GetGPR(it.Rs)>>it.Shamt&fieldMask(uint32(it.Rd)+1))
+}
+
+// ins: rd is pos+size-1 and shamt is pos
+func ins(it rinstr) {
+    mask := fieldMask(uint32(it.Rd)-uint32(it.Shamt)+1) << it.Shamt
+    cpu.SetGPR(it.Rt, cpu.GetGPR(it.Rt)&^mask|cpu.GetGPR(it.Rs)<<it.Shamt&mask)
+}
+
+func seb(it rinstr) {
+    cpu.SetGPR(it.Rd, uint32(int32(int8(cpu.GetGPR(it.Rt)))))
+}
+
+func seh(it rinstr) {
+    cpu.SetGPR(it.Rd, uint32(int32(int16(cpu.GetGPR(it.Rt)))))
+}
+
+// wsbh swaps the bytes in each half
+func wsbh(it rinstr) {
+    val := cpu.GetGPR(it.Rt)
+    cpu.SetGPR(it.Rd, val<<8&0xff00ff00|val>>8&0x00ff00ff)
+}
@@ -6,23 +6,23 @@ import (
 )
 
 func lb(it iinstr) {
-    cpu.SetGPR(it.Rt, signext(memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 1), 2))
+    setLoaded(it.Rt, signext(memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 1), 2))
 }
 
 func lbu(it iinstr) {
-    cpu.SetGPR(it.Rt, memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 1))
+    setLoaded(it.Rt, memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 1))
 }
 
 func lh(it iinstr) {
-    cpu.SetGPR(it.Rt, signext(memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 2), 2))
+    setLoaded(it.Rt, signext(memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 2), 2))
 }
 
 func lhu(it iinstr) {
-    cpu.SetGPR(it.Rt, memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 2))
+    setLoaded(it.Rt, memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 2))
 }
 
 func lw(it iinstr) {
-    cpu.SetGPR(it.Rt, memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 4))
+    setLoaded(it.Rt, memory.Read(cpu.GetGPR(it.Rs)+signext16(it.Imm), 4))
 }
 
 func sb(it iinstr) {
@@ -35,4 +35,43 @@ func sh(it iinstr) {
 
 func sw(it iinstr) {
     memory.Write(cpu.GetGPR(it.Rs)+signext16(it.Imm), 4, cpu.GetGPR(it.Rt))
-}
\ No newline at end of file
+}
+
+// the byte of the word at addr, counted from the least significant one
+func wordByte(addr uint32) uint32 {
+    b := addr & 3
+    if memory.IsBigEndian() {
+        b ^= 3
+    }
+    return b
+}
+
+// lwl loads the bytes from addr to the start of its word into the high bytes of rt
+func lwl(it iinstr) {
+    addr := cpu.GetGPR(it.Rs) + signext16(it.Imm)
+    shift := (3 - wordByte(addr)) << 3
+    word := memory.Read(addr&^3, 4)
+    setLoaded(it.Rt, word<<shift|mergedGPR(it.Rt)&(1<<shift-1))
+}
+
+// lwr loads the bytes from addr to the end of its word into the low bytes of rt
+func lwr(it iinstr) {
+    addr := cpu.GetGPR(it.Rs) + signext16(it.Imm)
+    shift := wordByte(addr) << 3
+    word := memory.Read(addr&^3, 4)
+    setLoaded(it.Rt, word>>shift|mergedGPR(it.Rt)&^(0xffffffff>>shift))
+}
+
+func swl(it iinstr) {
+    addr := cpu.GetGPR(it.Rs) + signext16(it.Imm)
+    shift := (3 - wordByte(addr)) << 3
+    word := memory.Read(addr&^3, 4)
+    memory.Write(addr&^3, 4, word&^(0xffffffff>>shift)|cpu.GetGPR(it.Rt)>>shift)
+}
+
+func swr(it iinstr) {
+    addr := cpu.GetGPR(it.Rs) + signext16(it.Imm)
+    shift := wordByte(addr) << 3
+    word := memory.Read(addr&^3, 4)
+    memory.Write(addr&^3, 4, word&(1<<shift-1)|cpu.GetGPR(it.Rt)<<shift)
+}
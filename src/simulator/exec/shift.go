@@ -1,6 +1,8 @@
 package exec
 
 import (
+    "math/bits"
+
     "../cpu"
 )
 
@@ -9,7 +11,7 @@ func sll(it rinstr) {
 }
 
 func sllv(it rinstr) {
-    cpu.SetGPR(it.Rd, cpu.GetGPR(it.Rt)<<cpu.GetGPR(it.Rs))
+    cpu.SetGPR(it.Rd, cpu.GetGPR(it.Rt)<<(cpu.GetGPR(it.Rs)&0x1f))
 }
 
 func sra(it rinstr) {
@@ -17,7 +19,7 @@ func sra(it rinstr) {
 }
 
 func srav(it rinstr) {
-    cpu.SetGPR(it.Rd, uint32(int32(cpu.GetGPR(it.Rt))>>cpu.GetGPR(it.Rs)))
+    cpu.SetGPR(it.Rd, uint32(int32(cpu.GetGPR(it.Rt))>>(cpu.GetGPR(it.Rs)&0x1f)))
 }
 
 func srl(it rinstr) {
@@ -25,5 +27,13 @@ func srl(it rinstr) {
 }
 
 func srlv(it rinstr) {
-    cpu.SetGPR(it.Rd, cpu.GetGPR(it.Rt)>>cpu.GetGPR(it.Rs))
+    cpu.SetGPR(it.Rd, cpu.GetGPR(it.Rt)>>(cpu.GetGPR(it.Rs)&0x1f))
+}
+
+func rotr(it rinstr) {
+    cpu.SetGPR(it.Rd, bits.RotateLeft32(cpu.GetGPR(it.Rt), -int(it.Shamt)))
+}
+
+func rotrv(it rinstr) {
+    cpu.SetGPR(it.Rd, bits.RotateLeft32(cpu.GetGPR(it.Rt), -int(cpu.GetGPR(it.Rs)&0x1f)))
 }
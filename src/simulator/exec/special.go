@@ -1,13 +1,16 @@
 package exec
 
 import (
+    "errors"
+    "fmt"
+
     "../../instruction"
     "../cpu"
     "../memory"
 )
 
 func retrieveCode() uint32 {
-    return memory.Read(cpu.PC,4) >> 6 & 0xfffff
+    return memory.Fetch(cpu.PC) >> 6 & 0xfffff
 }
 
 func syscall(it rinstr) {
@@ -20,3 +23,52 @@ func syscall(it rinstr) {
 func _break(it rinstr){
     doBreak(retrieveCode())
 }
+
+// the memories have no caches and are accessed in order, so these do nothing
+func sync(it rinstr) {
+}
+
+func pref(it iinstr) {
+}
+
+func cache(it iinstr) {
+}
+
+// the hardware registers of rdhwr
+const (
+    HWR_CPUNUM     = 0
+    HWR_SYNCI_STEP = 1
+    HWR_CC         = 2
+    HWR_CCRES      = 3
+)
+
+// rdhwr reads the hardware registers, the cycle counter counts the instructions executed
+func rdhwr(it rinstr) {
+    switch it.Rd {
+    case HWR_CPUNUM:
+        cpu.SetGPR(it.Rt, 0)
+    case HWR_SYNCI_STEP: // no caches to synchronize
+        cpu.SetGPR(it.Rt, 0)
+    case HWR_CC:
+        cpu.SetGPR(it.Rt, cycles)
+    case HWR_CCRES:
+        cpu.SetGPR(it.Rt, 1)
+    default:
+        panic(errors.New(fmt.Sprintf("No this hardware register %d", it.Rd)))
+    }
+}
+
+// di and ei only change the interrupt enable bit, there are no interrupts
+func di(it rinstr) {
+    if it.Rt != 0 {
+        cpu.SetGPR(it.Rt, cpu.GetStatus())
+    }
+    cpu.SetStatus(cpu.GetStatus() &^ cpu.STATUS_IE)
+}
+
+func ei(it rinstr) {
+    if it.Rt != 0 {
+        cpu.SetGPR(it.Rt, cpu.GetStatus())
+    }
+    cpu.SetStatus(cpu.GetStatus() | cpu.STATUS_IE)
+}
@@ -0,0 +1,64 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+
+	"../cpu"
+)
+
+// there are no exception handlers, so a trap stops the program like the other errors
+func trapIf(cond bool, code uint32) {
+	if cond {
+		panic(errors.New(fmt.Sprintf("Trap %d at 0x%08x", code, cpu.PC)))
+	}
+}
+
+func teq(it rinstr) {
+	trapIf(cpu.GetGPR(it.Rs) == cpu.GetGPR(it.Rt), it.TrapCode())
+}
+
+func tne(it rinstr) {
+	trapIf(cpu.GetGPR(it.Rs) != cpu.GetGPR(it.Rt), it.TrapCode())
+}
+
+func tge(it rinstr) {
+	trapIf(int32(cpu.GetGPR(it.Rs)) >= int32(cpu.GetGPR(it.Rt)), it.TrapCode())
+}
+
+func tgeu(it rinstr) {
+	trapIf(cpu.GetGPR(it.Rs) >= cpu.GetGPR(it.Rt), it.TrapCode())
+}
+
+func tlt(it rinstr) {
+	trapIf(int32(cpu.GetGPR(it.Rs)) < int32(cpu.GetGPR(it.Rt)), it.TrapCode())
+}
+
+func tltu(it rinstr) {
+	trapIf(cpu.GetGPR(it.Rs) < cpu.GetGPR(it.Rt), it.TrapCode())
+}
+
+func teqi(it iinstr) {
+	trapIf(cpu.GetGPR(it.Rs) == signext16(it.Imm), 0)
+}
+
+func tnei(it iinstr) {
+	trapIf(cpu.GetGPR(it.Rs) != signext16(it.Imm), 0)
+}
+
+func tgei(it iinstr) {
+	trapIf(int32(cpu.GetGPR(it.Rs)) >= int32(signext16(it.Imm)), 0)
+}
+
+// the immediate is sign extended, then compared unsigned
+func tgeiu(it iinstr) {
+	trapIf(cpu.GetGPR(it.Rs) >= signext16(it.Imm), 0)
+}
+
+func tlti(it iinstr) {
+	trapIf(int32(cpu.GetGPR(it.Rs)) < int32(signext16(it.Imm)), 0)
+}
+
+func tltiu(it iinstr) {
+	trapIf(cpu.GetGPR(it.Rs) < signext16(it.Imm), 0)
+}
@@ -9,9 +9,67 @@ const MEMORY_SIZE uint32 = 0x8000
 
 var memory [MEMORY_SIZE]uint8
 
+// the memory the instructions are fetched from in the split mode, like a Harvard CPU
+var instructions [MEMORY_SIZE]uint8
+var split = false
+
+// the most significant byte of a word or a half is at the lowest address
+var bigEndian = false
+
 var _MASK_BYTE = [5]uint32{0x0, 0xff, 0xffff, 0xffffff, 0xffffffff}
 
+// SetSplit makes the instructions fetched from a memory of their own, or from the data memory
+func SetSplit(val bool) {
+	split = val
+}
+
+func IsSplit() bool {
+	return split
+}
+
+// SetBigEndian sets the byte order of the words and the halves read and written
+func SetBigEndian(val bool) {
+	bigEndian = val
+}
+
+func IsBigEndian() bool {
+	return bigEndian
+}
+
+// the shift of the byte at offset i of a value of len bytes
+func byteShift(i uint8, len uint8) uint32 {
+	if bigEndian {
+		return uint32(len-1-i) << 3
+	}
+	return uint32(i) << 3
+}
+
 func Read(addr uint32, len uint8) uint32 {
+	return read(&memory, addr, len)
+}
+
+func Write(addr uint32, len uint8, val uint32) {
+	write(&memory, addr, len, val)
+}
+
+// Fetch reads the instruction at addr, from the instruction memory in the split mode
+func Fetch(addr uint32) uint32 {
+	if split {
+		return read(&instructions, addr, 4)
+	}
+	return read(&memory, addr, 4)
+}
+
+// WriteInstruction writes the instruction memory in the split mode, or the data memory
+func WriteInstruction(addr uint32, len uint8, val uint32) {
+	if split {
+		write(&instructions, addr, len, val)
+	} else {
+		write(&memory, addr, len, val)
+	}
+}
+
+func read(memory *[MEMORY_SIZE]uint8, addr uint32, len uint8) uint32 {
 	if !(len == 1 || len == 2 || len == 4) {
 		panic(errors.New(fmt.Sprintf("Memory rw with unexpected len %d", len)))
 	}
@@ -20,12 +78,12 @@ func Read(addr uint32, len uint8) uint32 {
 	}
 	var result uint32 = 0
 	for i := uint8(0); i < len; i++ {
-		result |= uint32(memory[addr+uint32(i)]) << (uint32(i) << 3)
+		result |= uint32(memory[addr+uint32(i)]) << byteShift(i, len)
 	}
 	return result & _MASK_BYTE[len]
 }
 
-func Write(addr uint32, len uint8, val uint32) {
+func write(memory *[MEMORY_SIZE]uint8, addr uint32, len uint8, val uint32) {
 	if !(len == 1 || len == 2 || len == 4) {
 		panic(errors.New(fmt.Sprintf("Memory rw with unexpected len %d", len)))
 	}
@@ -34,6 +92,6 @@ func Write(addr uint32, len uint8, val uint32) {
 	}
 	val &= _MASK_BYTE[len]
 	for i := uint8(0); i < len; i++ {
-		memory[addr+uint32(i)] = uint8(val >> (uint32(i) << 3) & _MASK_BYTE[1])
+		memory[addr+uint32(i)] = uint8(val >> byteShift(i, len) & _MASK_BYTE[1])
 	}
 }
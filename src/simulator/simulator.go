@@ -19,7 +19,9 @@ func executeOne(instr instruction.Instruction) {
 	}
 	token := instr.GetToken()
 	exc, ok := exec.ExecTable[token]
-	if !ok {
+	if !ok && instruction.Revision(token) > instruction.MIPS32R1 {
+		panic(errors.New(fmt.Sprintf("The instruction %s is in MIPS32 release %d", token, instruction.Revision(token))))
+	} else if !ok {
 		panic(errors.New(fmt.Sprintf("No this instruction %s", token)))
 	}
 	switch exc.(type) {
@@ -46,11 +48,16 @@ func executeOne(instr instruction.Instruction) {
 	}
 }
 
+// print only the message of an error while executing, without the stack
+var quiet bool
+
 func handleErrorWhileExecuting() {
 	if err := recover(); err != nil {
 		exec.State = exec.MEMU_ERROR
 		fmt.Printf("Error %s\n", err.(error).Error())
-		debug.PrintStack()
+		if !quiet {
+			debug.PrintStack()
+		}
 	}
 }
 
@@ -67,7 +74,7 @@ func Execute(entry uint32, isdebug bool) bool {
 	exec.InitializePC(entry)
 
 	for {
-		bits := memory.Read(cpu.PC, 4)
+		bits := memory.Fetch(cpu.PC)
 		instr := instruction.Parse(bits)
 		executeOne(instr)
 		if exec.State != exec.MEMU_RUNNING {
@@ -75,24 +82,70 @@ func Execute(entry uint32, isdebug bool) bool {
 		}
 		exec.UpdatePC()
 	}
+	exec.RetireLoads()
 
 	return exec.State == exec.MEMU_EXITED
 }
 
-func Initialize(bin []uint8, breakH exec.SignalHandler, syscallH exec.SignalHandler) bool {
+// Config is how the simulator runs the program, the zero value is the little-endian
+// MIPS32 with the branch delay slots and without the load delay slots
+type Config struct {
+	BigEndian   bool // the byte order of the memories
+	NoDelaySlot bool // run the branches and the jumps at once, like MARS
+	LoadDelay   bool // the value of a load is only seen from the second instruction after it, like MIPS I
+	ISARevision int  // the release of MIPS32 whose instructions are run, instruction.MIPS32R1 if 0
+	Quiet       bool // report an error of the program in one line, without the stack of the simulator
+}
+
+func (this Config) apply() {
+	quiet = this.Quiet
+	memory.SetBigEndian(this.BigEndian)
+	exec.Configure(exec.Config{NoDelaySlot: this.NoDelaySlot, LoadDelay: this.LoadDelay, ISARevision: this.ISARevision})
+}
+
+func Initialize(bin []uint8, config Config, breakH exec.SignalHandler, syscallH exec.SignalHandler) bool {
+	memory.SetSplit(false)
+	config.apply()
+	reset(breakH, syscallH)
+	for i, bits := range bin {
+		memory.Write(uint32(i), 1, uint32(bits))
+	}
+	exec.State = exec.MEMU_INITIALIZED
+	return true
+}
+
+// InitializeSplit loads the code into the instruction memory at codeBase and the data
+// into the data memory at dataBase, the instructions are only fetched from the former
+func InitializeSplit(code []uint8, codeBase uint32, data []uint8, dataBase uint32, config Config, breakH exec.SignalHandler, syscallH exec.SignalHandler) bool {
+	if uint64(codeBase)+uint64(len(code)) > uint64(memory.MEMORY_SIZE) || uint64(dataBase)+uint64(len(data)) > uint64(memory.MEMORY_SIZE) {
+		fmt.Printf("The images are out of the memories of 0x%x bytes\n", memory.MEMORY_SIZE)
+		return false
+	}
+	memory.SetSplit(true)
+	config.apply()
+	reset(breakH, syscallH)
+	for i, bits := range code {
+		memory.WriteInstruction(codeBase+uint32(i), 1, uint32(bits))
+	}
+	for i, bits := range data {
+		memory.Write(dataBase+uint32(i), 1, uint32(bits))
+	}
+	exec.State = exec.MEMU_INITIALIZED
+	return true
+}
+
+// clear the registers and the memories
+func reset(breakH exec.SignalHandler, syscallH exec.SignalHandler) {
 	exec.InitializeTable(breakH, syscallH)
 	exec.State = exec.MEMU_EXITED
 	for i := 0; i < 32; i++ {
 		cpu.SetGPR(uint8(i), 0)
 	}
+	cpu.SetStatus(0)
 	for i := uint32(0); i < memory.MEMORY_SIZE; i++ {
 		memory.Write(uint32(i), 1, 0)
+		memory.WriteInstruction(uint32(i), 1, 0)
 	}
-	for i, bits := range bin {
-		memory.Write(uint32(i), 1, uint32(bits))
-	}
-	exec.State = exec.MEMU_INITIALIZED
-	return true
 }
 
 func ShowRegisters() {
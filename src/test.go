@@ -2,9 +2,13 @@ package main
 
 import (
     "fmt"
+    "os"
+    "path/filepath"
+    "strings"
 
 	ass "./assembler"
 	ins "./instruction"
+	"./memfile"
 	sim "./simulator"
 	"./simulator/cpu"
 )
@@ -14,17 +18,69 @@ func createTestInstructions() []ins.Instruction {
     instrs = append(instrs, ins.Lw(2, 0, 0x0))
     instrs = append(instrs, ins.Lw(3, 0, 0x1))
     instrs = append(instrs, ins.Add(1, 2, 3))
+    instrs = append(instrs, ins.Lwl(2, 3, 0x5))
+    instrs = append(instrs, ins.Lwr(2, 3, 0x2))
+    instrs = append(instrs, ins.Swl(2, 3, 0x9))
+    instrs = append(instrs, ins.Swr(2, 3, 0xe))
+    instrs = append(instrs, ins.Movn(1, 2, 3))
+    instrs = append(instrs, ins.Movz(1, 2, 3))
+    instrs = append(instrs, ins.Clo(1, 2))
+    instrs = append(instrs, ins.Clz(1, 2))
+    instrs = append(instrs, ins.Madd(2, 3))
+    instrs = append(instrs, ins.Maddu(2, 3))
+    instrs = append(instrs, ins.Msub(2, 3))
+    instrs = append(instrs, ins.Msubu(2, 3))
+    instrs = append(instrs, ins.Teq(2, 3, 7))
+    instrs = append(instrs, ins.Tne(2, 3, 0))
+    instrs = append(instrs, ins.Tge(2, 3, 0))
+    instrs = append(instrs, ins.Tgeu(2, 3, 0))
+    instrs = append(instrs, ins.Tlt(2, 3, 0))
+    instrs = append(instrs, ins.Tltu(2, 3, 0))
+    instrs = append(instrs, ins.Teqi(2, 0x1))
+    instrs = append(instrs, ins.Tnei(2, 0x1))
+    instrs = append(instrs, ins.Tgei(2, 0x1))
+    instrs = append(instrs, ins.Tgeiu(2, 0x1))
+    instrs = append(instrs, ins.Tlti(2, 0x1))
+    instrs = append(instrs, ins.Tltiu(2, 0x1))
+    instrs = append(instrs, ins.Sync(0))
+    instrs = append(instrs, ins.Pref(0, 3, 0x0))
+    instrs = append(instrs, ins.Cache(0x14, 3, 0x4))
+    instrs = append(instrs, ins.Beql(2, 3, 0x4))
+    instrs = append(instrs, ins.Bnel(2, 3, 0x4))
+    instrs = append(instrs, ins.Blezl(2, 0x4))
+    instrs = append(instrs, ins.Bgtzl(2, 0x4))
+    instrs = append(instrs, ins.Bgezl(2, 0x4))
+    instrs = append(instrs, ins.Bgezall(2, 0x4))
+    instrs = append(instrs, ins.Bltzl(2, 0x4))
+    instrs = append(instrs, ins.Bltzall(2, 0x4))
+    instrs = append(instrs, ins.Bltzal(2, 0x4))
+    instrs = append(instrs, ins.Mthi(2))
+    instrs = append(instrs, ins.Mtlo(2))
+    instrs = append(instrs, ins.Bgtz(2, 0x4))
+    instrs = append(instrs, ins.Rotr(1, 2, 8))
+    instrs = append(instrs, ins.Rotrv(1, 2, 3))
+    instrs = append(instrs, ins.Ext(1, 2, 4, 8))
+    instrs = append(instrs, ins.Ins(1, 2, 8, 12))
+    instrs = append(instrs, ins.Wsbh(1, 2))
+    instrs = append(instrs, ins.Seb(1, 2))
+    instrs = append(instrs, ins.Seh(1, 2))
+    instrs = append(instrs, ins.Rdhwr(1, 2))
+    instrs = append(instrs, ins.Di(0))
+    instrs = append(instrs, ins.Ei(2))
     return instrs
 }
 
-func testToAndParse(instrs []ins.Instruction) {
+func testToAndParse(instrs []ins.Instruction) bool {
+    result := true
     for _, instr := range instrs {
         to := instr.ToBits()
         parsed := ins.Parse(to)
-        if parsed.GetToken() != instr.GetToken() {
+        if parsed.GetToken() != instr.GetToken() || parsed.ToBits() != to {
             fmt.Println("Error", parsed.GetToken(), instr.GetToken(), instr.ToASM())
+            result = false
         }
     }
+    return result
 }
 
 const OUT_ASM = "./test/output.asm"
@@ -96,7 +152,10 @@ func testAssemble() {
 	}
 	println("Bin file:", OUT_BIN)
 
-	err = writeAllLines(OUT_MIF, toMIF(builded.Bin))
+	mif, err := memfile.ToMIF(builded.Bin, memfile.NewOptions(8))
+	if err == nil {
+		err = writeAllLines(OUT_MIF, mif)
+	}
 	if err != nil {
 		println("Generate mif file failed", err)
 		return
@@ -109,7 +168,7 @@ func testAssemble() {
 	}
 
 	print("Initializing for simulating...")
-	if !sim.Initialize(builded.Bin, breakHandler, nil) {
+	if !sim.Initialize(builded.Bin, sim.Config{}, breakHandler, nil) {
 		println("failed")
 		return
 	}
@@ -121,3 +180,1344 @@ func testAssemble() {
 	fmt.Println("Registers")
 	sim.ShowRegisters()
 }
+
+// testFixedEncodings checks the instructions whose encodings were wrong in the baseline
+// against the bits of the MIPS32 manual
+func testFixedEncodings() bool {
+	cases := []struct {
+		instr ins.Instruction
+		token string
+		bits  uint32
+	}{
+		{ins.Bltzal(2, 4), "bltzal", 0x04500004}, // rt 0x10, not 0x20
+		{ins.Bgtz(2, 4), "bgtz", 0x1c400004},     // not the token of bgez
+		{ins.Mthi(2), "mthi", 0x00400011},        // not the funct of mfhi
+		{ins.Mtlo(2), "mtlo", 0x00400013},        // not the funct of mflo
+	}
+	result := true
+	for _, c := range cases {
+		parsed := ins.Parse(c.bits)
+		if c.instr.ToBits() != c.bits || c.instr.GetToken() != c.token || parsed.GetToken() != c.token {
+			fmt.Printf("%s: 0x%08x %s, parsed as %s, expect 0x%08x\n", c.token, c.instr.ToBits(), c.instr.GetToken(), parsed.GetToken(), c.bits)
+			result = false
+		}
+	}
+	return result
+}
+
+// the program of testEndianness, which loads and stores the words, halves and bytes
+// by their own sizes, so the results don't depend on the byte order
+var endiannessProgram = []string{
+	".data",
+	"word: .word 0x11223344, 0x8899aabb",
+	"half: .half 0x5566, 0xccdd",
+	"byte: .byte 0x77, 0xee",
+	"out: .space 8",
+	".text",
+	"main:",
+	"    lw $t0, word",
+	"    lw $t1, word+4",
+	"    lh $t2, half+2",
+	"    lhu $t3, half",
+	"    lb $t4, byte+1",
+	"    lbu $t5, byte",
+	"    sw $t1, out",
+	"    sh $t2, out+4",
+	"    sb $t4, out+6",
+	"    sb $t5, out+7",
+	"    lw $t6, out",
+	"    lhu $t7, out+4",
+	"    lb $t8, out+6",
+	"    lbu $t9, out+7",
+	"    li $v0, 10",
+	"    syscall",
+}
+
+// runTestProgram assembles the program and runs it to the end, gives the registers and the image,
+// ok is false if the program can't be assembled or stops with an error, the registers are
+// those at the stop if it has run
+func runTestProgram(program []string, config ass.AssembleConfig, simConfig sim.Config) ([]uint32, ass.AssembleResult, bool) {
+	config.Data, config.Text = 0x00003000, 0x00001000
+	simConfig.Quiet = true // some tests stop the program on purpose
+	_, builded, err := ass.Assemble(program, config, 0x4000)
+	if err != nil {
+		println(err.Error())
+		return nil, builded, false
+	}
+	if !sim.Initialize(builded.Bin, simConfig, breakHandler, nil) {
+		return nil, builded, false
+	}
+	ok := sim.Execute(builded.Entry, false)
+	return simRegisters(), builded, ok
+}
+
+// the registers of the simulator after a run
+func simRegisters() []uint32 {
+	regs := make([]uint32, 32)
+	for i := range regs {
+		regs[i] = cpu.GetGPR(uint8(i))
+	}
+	return regs
+}
+
+// expectRegisters compares the registers with the expected values, and prints the mismatches
+func expectRegisters(name string, regs []uint32, expected map[uint8]uint32) bool {
+	result := true
+	for reg := uint8(0); reg < 32; reg++ {
+		if val, ok := expected[reg]; ok && regs[reg] != val {
+			fmt.Printf("%s: $%d is 0x%08x, expect 0x%08x\n", name, reg, regs[reg], val)
+			result = false
+		}
+	}
+	return result
+}
+
+// expectBytes compares the bytes of the image from addr with the expected ones, and prints the first mismatch
+func expectBytes(name string, image []uint8, addr uint32, expected []uint8) bool {
+	for i, val := range expected {
+		if int(addr)+i >= len(image) || image[int(addr)+i] != val {
+			fmt.Printf("%s: the byte at 0x%x isn't 0x%02x\n", name, int(addr)+i, val)
+			return false
+		}
+	}
+	return true
+}
+
+// run the program in one byte order, gives the registers and the data in the image
+func runEndianness(bigEndian bool) ([]uint32, []uint8, bool) {
+	regs, builded, ok := runTestProgram(endiannessProgram, ass.AssembleConfig{BigEndian: bigEndian}, sim.Config{BigEndian: bigEndian})
+	if !ok {
+		return nil, nil, false
+	}
+	return regs, builded.Bin[builded.Data.Start:builded.Data.End], true
+}
+
+// testEndianness runs the same program in both byte orders, the registers should be
+// the same while the bytes of the words and the halves in the image are reversed
+func testEndianness() bool {
+	little, littleImage, ok := runEndianness(false)
+	if !ok {
+		println("Little-endian run failed")
+		return false
+	}
+	big, bigImage, ok := runEndianness(true)
+	if !ok {
+		println("Big-endian run failed")
+		return false
+	}
+	result := true
+	for i := range little {
+		if little[i] != big[i] {
+			fmt.Printf("Mismatch of $%d: little 0x%08x, big 0x%08x\n", i, little[i], big[i])
+			result = false
+		}
+	}
+	for _, span := range [][2]int{{0, 4}, {4, 8}, {8, 10}, {10, 12}} {
+		for i := span[0]; i < span[1]; i++ {
+			if littleImage[i] != bigImage[span[0]+span[1]-1-i] {
+				fmt.Printf("The bytes at 0x%x aren't reversed\n", span[0])
+				result = false
+				break
+			}
+		}
+	}
+	if littleImage[12] != bigImage[12] || littleImage[13] != bigImage[13] {
+		println("The bytes are changed")
+		result = false
+	}
+	return result
+}
+
+// the unaligned word at 1 is read by lwr/lwl and written by swr/swl, the two
+// offsets of the pair swap in big-endian mode
+func unalignedProgram(bigEndian bool) []string {
+	low, high := "1", "4" // the addresses of the least and the most significant bytes
+	if bigEndian {
+		low, high = high, low
+	}
+	return []string{
+		".data",
+		"bytes: .byte 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88",
+		"out: .word 0, 0",
+		".text",
+		"    la $t1, bytes",
+		"    li $t0, 0x99999999",
+		"    lwr $t0, " + low + "($t1)",
+		"    lwl $t0, " + high + "($t1)",
+		"    li $t2, 0xa1b2c3d4",
+		"    la $t3, out",
+		"    swr $t2, " + low + "($t3)",
+		"    swl $t2, " + high + "($t3)",
+		"    lw $t4, 0($t3)",
+		"    lw $t5, 4($t3)",
+		"    li $t6, 0x99999999",
+		"    lwr $t6, 5($t1)", // only a part of the register is changed
+		"    li $v0, 10",
+		"    syscall",
+	}
+}
+
+// testUnaligned checks lwl, lwr, swl and swr in both byte orders
+func testUnaligned() bool {
+	little, _, ok := runTestProgram(unalignedProgram(false), ass.AssembleConfig{}, sim.Config{})
+	if !ok {
+		return false
+	}
+	big, _, ok := runTestProgram(unalignedProgram(true), ass.AssembleConfig{BigEndian: true}, sim.Config{BigEndian: true})
+	if !ok {
+		return false
+	}
+	littleOK := expectRegisters("little", little, map[uint8]uint32{
+		ins.GPR_T0: 0x55443322, ins.GPR_T4: 0xb2c3d400, ins.GPR_T5: 0x000000a1, ins.GPR_T6: 0x99887766})
+	bigOK := expectRegisters("big", big, map[uint8]uint32{
+		ins.GPR_T0: 0x22334455, ins.GPR_T4: 0x00a1b2c3, ins.GPR_T5: 0xd4000000, ins.GPR_T6: 0x99995566})
+	return littleOK && bigOK
+}
+
+// testAccumulator checks madd, maddu, msub and msubu on the 64-bit hi:lo
+func testAccumulator() bool {
+	regs, _, ok := runTestProgram([]string{
+		".text",
+		"    mthi $zero",
+		"    mtlo $zero",
+		"    li $t0, -2",
+		"    li $t1, 3",
+		"    madd $t0, $t1", // -6
+		"    mfhi $s0",
+		"    mflo $s1",
+		"    maddu $t0, $t1", // -6 + 0xfffffffe * 3
+		"    mfhi $s2",
+		"    mflo $s3",
+		"    msub $t0, $t1",
+		"    msubu $t0, $t1",
+		"    mfhi $s4",
+		"    mflo $s5",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	return ok && expectRegisters("accumulator", regs, map[uint8]uint32{
+		ins.GPR_S0: 0xffffffff, ins.GPR_S1: 0xfffffffa,
+		ins.GPR_S2: 0x00000002, ins.GPR_S3: 0xfffffff4,
+		ins.GPR_S4: 0x00000000, ins.GPR_S5: 0x00000000})
+}
+
+// testTraps runs the traps whose conditions are false to the end, and each trap
+// whose condition is true must stop the program
+func testTraps() bool {
+	prologue := []string{".text", "    li $t0, 1", "    li $t1, 2", "    li $t2, -1"}
+	epilogue := []string{"    li $v0, 10", "    syscall"}
+	notTaken := []string{
+		"teq $t0, $t1", "tne $t0, $t0", "tge $t0, $t1", "tge $t2, $t0", "tgeu $t0, $t1", "tlt $t1, $t0", "tltu $t2, $t0",
+		"teqi $t0, 2", "tnei $t0, 1", "tgei $t0, 2", "tgeiu $t0, 2", "tlti $t0, 1", "tltiu $t0, 1",
+	}
+	taken := []string{
+		"teq $t0, $t0", "tne $t0, $t1", "tge $t1, $t0", "tgeu $t2, $t0", "tlt $t2, $t0", "tltu $t0, $t1",
+		"teqi $t0, 1", "tnei $t0, 2", "tgei $t0, 1", "tgeiu $t2, 1", "tlti $t2, 0", "tltiu $t0, 2",
+	}
+	program := append([]string{}, prologue...)
+	for _, trap := range notTaken {
+		program = append(program, "    "+trap)
+	}
+	result := true
+	if _, _, ok := runTestProgram(append(program, epilogue...), ass.AssembleConfig{}, sim.Config{}); !ok {
+		println("A trap is taken while its condition is false")
+		result = false
+	}
+	for _, trap := range taken {
+		program := append(append(append([]string{}, prologue...), "    "+trap), epilogue...)
+		if _, _, ok := runTestProgram(program, ass.AssembleConfig{}, sim.Config{}); ok {
+			println("Not trapped:", trap)
+			result = false
+		}
+	}
+	return result
+}
+
+// the slot of a branch-likely runs only if the branch is taken, and only with the delay slots
+var branchLikelyProgram = []string{
+	".set noreorder",
+	".text",
+	"    li $t0, 0",
+	"    li $t1, 1",
+	"    li $t2, 0",
+	"    beql $t0, $t1, out", // not taken
+	"    addiu $t0, $t0, 10",
+	"    addiu $t0, $t0, 1",
+	"    beql $t1, $t1, taken",
+	"    addiu $t2, $t2, 5",
+	"    addiu $t2, $t2, 100", // jumped over
+	"taken:",
+	"    bltzall $t1, out", // not taken, but links
+	"    addiu $t0, $t0, 20",
+	"out:",
+	"    li $v0, 10",
+	"    syscall",
+	"    nop",
+}
+
+// testBranchLikely runs branchLikelyProgram with and without the delay slots
+func testBranchLikely() bool {
+	delay, builded, ok := runTestProgram(branchLikelyProgram, ass.AssembleConfig{NoReorder: true}, sim.Config{})
+	if !ok {
+		return false
+	}
+	noDelay, _, ok := runTestProgram(branchLikelyProgram, ass.AssembleConfig{NoReorder: true}, sim.Config{NoDelaySlot: true})
+	if !ok {
+		return false
+	}
+	bltzall := builded.Text.Start + 9*4
+	delayOK := expectRegisters("delay slots", delay, map[uint8]uint32{
+		ins.GPR_T0: 1, ins.GPR_T2: 5, ins.GPR_RA: bltzall + 8})
+	noDelayOK := expectRegisters("no delay slots", noDelay, map[uint8]uint32{
+		ins.GPR_T0: 31, ins.GPR_T2: 0, ins.GPR_RA: bltzall + 4})
+	return delayOK && noDelayOK
+}
+
+// the MIPS32 release 2 instructions, with ISARevision 2 in both the assembler and the simulator
+var release2Program = []string{
+	".text",
+	"    li $t0, 0x12345678",
+	"    rotr $t1, $t0, 8",
+	"    li $t2, 4",
+	"    rotrv $t3, $t0, $t2",
+	"    ext $t4, $t0, 4, 8",
+	"    li $t5, -1",
+	"    ins $t5, $t0, 8, 12",
+	"    wsbh $t6, $t0",
+	"    li $t7, 0x1280",
+	"    seb $s0, $t7",
+	"    li $t8, 0x18000",
+	"    seh $s1, $t8",
+	"    ei",
+	"    di $s2", // the status before, with the interrupts enabled
+	"    ei $s3",
+	"    rdhwr $s4, $3", // the resolution of the cycle counter
+	"    rdhwr $s5, $2",
+	"    nop",
+	"    rdhwr $s6, $2",
+	"    subu $s6, $s6, $s5",
+	"    li $v0, 10",
+	"    syscall",
+}
+
+// testRelease2 runs release2Program, which is rejected by both the assembler and
+// the simulator in release 1
+func testRelease2() bool {
+	r2 := ass.AssembleConfig{ISARevision: ins.MIPS32R2}
+	regs, _, ok := runTestProgram(release2Program, r2, sim.Config{ISARevision: ins.MIPS32R2})
+	if !ok {
+		return false
+	}
+	result := expectRegisters("release 2", regs, map[uint8]uint32{
+		ins.GPR_T1: 0x78123456, ins.GPR_T3: 0x81234567, ins.GPR_T4: 0x00000067,
+		ins.GPR_T5: 0xfff678ff, ins.GPR_T6: 0x34127856, ins.GPR_S0: 0xffffff80,
+		ins.GPR_S1: 0xffff8000, ins.GPR_S2: cpu.STATUS_IE, ins.GPR_S3: 0,
+		ins.GPR_S4: 1, ins.GPR_S6: 2})
+	if _, _, err := ass.Assemble(release2Program, ass.AssembleConfig{Data: 0x00003000, Text: 0x00001000}, 0x4000); err == nil {
+		println("Release 2 instructions are assembled for release 1")
+		result = false
+	}
+	if _, _, ok := runTestProgram(release2Program, r2, sim.Config{}); ok {
+		println("Release 2 instructions are run in release 1")
+		result = false
+	}
+	return result
+}
+
+// the linking branches to a target out of the memory, which are relaxed; the taken one
+// stops the run at the target, after the link and the delay slot
+var relaxLinkProgram = []string{
+	".eqv far, 0x08000000",
+	".text",
+	"main:",
+	"    la $s1, after1",
+	"    li $t0, -1",
+	"    bgezal $t0, far", // not taken
+	"    addiu $s3, $s3, 1",
+	"after1:",
+	"    move $s0, $ra",
+	"    la $s4, after2",
+	"    bgezall $t0, far", // not taken, the slot is skipped
+	"    addiu $s3, $s3, 1",
+	"after2:",
+	"    move $s5, $ra",
+	"    la $s2, after3",
+	"    li $t0, 1",
+	"    bgezal $t0, far",
+	"    addiu $s3, $s3, 1",
+	"after3:",
+	"    li $v0, 10",
+	"    syscall",
+}
+
+// testRelaxLink checks that a relaxed linking branch links the address after its delay slot
+// whether it is taken or not
+func testRelaxLink() bool {
+	regs, _, ok := runTestProgram(relaxLinkProgram, ass.AssembleConfig{Relax: true, NoReorder: true}, sim.Config{})
+	if ok || regs == nil {
+		println("The taken branch doesn't reach the target")
+		return false
+	}
+	if cpu.PC != 0x08000000 {
+		fmt.Printf("The run stops at 0x%08x, expect the target\n", cpu.PC)
+		return false
+	}
+	return expectRegisters("relax", regs, map[uint8]uint32{
+		ins.GPR_S0: regs[ins.GPR_S1], ins.GPR_S5: regs[ins.GPR_S4], ins.GPR_RA: regs[ins.GPR_S2], ins.GPR_S3: 2})
+}
+
+// testRelaxSections relaxes the branches between .text and a .ktext far after it, in both directions
+func testRelaxSections() bool {
+	program := []string{
+		".text",
+		"main:",
+		"    beq $t0, $t1, handler",
+		"    nop",
+		"back:",
+		"    jr $ra",
+		"    nop",
+		".ktext",
+		"handler:",
+		"    bne $t0, $t1, back",
+		"    nop",
+	}
+	config := ass.AssembleConfig{Text: 0x0, Data: 0x100, Sections: map[string]uint32{"ktext": 0x40000}, NoReorder: true}
+	if _, _, err := ass.Assemble(program, config, 0x50000); err == nil {
+		println("The branches out of range are accepted")
+		return false
+	}
+	config.Relax = true
+	if _, _, err := ass.Assemble(program, config, 0x50000); err != nil {
+		println(err.Error())
+		return false
+	}
+	return true
+}
+
+// the argument done of jmpto is the label of the caller, not the one in the macro body,
+// and the label names in the strings and the registers of the body are kept
+var macroLabelsProgram = []string{
+	".macro jmpto(%dst)",
+	"    .data",
+	"str: .asciiz \"done\"",
+	"    .text",
+	"    la $s5, str",
+	"    lbu $s3, 3($s5)",
+	"    lbu $s4, 4($s5)",
+	"    j %dst",
+	"    nop",
+	"done:",
+	"t0: addiu $t0, $t0, 1",
+	".end_macro",
+	".text",
+	"    li $s1, 1",
+	"    jmpto(done)",
+	"    li $s1, 2",
+	"done:",
+	"    li $v0, 10",
+	"    syscall",
+}
+
+func testMacroLabels() bool {
+	regs, _, ok := runTestProgram(macroLabelsProgram, ass.AssembleConfig{}, sim.Config{})
+	return ok && expectRegisters("macro", regs, map[uint8]uint32{
+		ins.GPR_S1: 1, ins.GPR_T0: 0, ins.GPR_S3: 'e', ins.GPR_S4: 0})
+}
+
+// testRanges assembles the operands and the data items at the ends of their ranges,
+// and each one just out of its range must be an error
+func testRanges() bool {
+	config := ass.AssembleConfig{Data: 0x00003000, Text: 0x00001000}
+	inRange := []string{
+		".eqv BIG, 0x100000000",
+		".data",
+		".word 0xffffffff, -0x80000000",
+		".half 0xffff, -0x8000",
+		".byte 255, -128",
+		".text",
+		"    li $t0, 0xffffffff",
+		"    li $t1, -0x80000000",
+		"    li $t2, BIG-1",
+	}
+	result := true
+	if _, _, err := ass.Assemble(inRange, config, 0x4000); err != nil {
+		println(err.Error())
+		result = false
+	}
+	outOfRange := []string{
+		".word 0x100000000", ".word -0x80000001", ".half 0x10000", ".half -0x8001", ".byte 256", ".byte -129",
+		"li $t0, 0x100000001", "addi $t1, $zero, 0x1ffffffff", "li $t2, BIG", "li $t3, -0x80000001",
+	}
+	for _, line := range outOfRange {
+		program := []string{".eqv BIG, 0x100000000", ".text", "    " + line}
+		if strings.HasPrefix(line, ".") {
+			program[1] = ".data"
+		}
+		if _, _, err := ass.Assemble(program, config, 0x4000); err == nil {
+			println("Out of range, but accepted:", line)
+			result = false
+		}
+	}
+	return result
+}
+
+// testSetOptions runs a program with the .set options of other assemblers, which are ignored,
+// next to a .set constant
+func testSetOptions() bool {
+	regs, _, ok := runTestProgram([]string{
+		".set noat",
+		".set mips32r2",
+		".set nomacro",
+		".set SIZE, 12",
+		".text",
+		"    li $t0, SIZE",
+		".set at",
+		".set macro",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	return ok && expectRegisters("set", regs, map[uint8]uint32{ins.GPR_T0: 12})
+}
+
+// testDiagnostics checks that the errors come once each and in the order of the lines: the word
+// is resolved after the text, and the second half of the lw is moved into the delay slot of j
+func testDiagnostics() bool {
+	_, _, err := ass.Assemble([]string{
+		".data",
+		"    .word nodata",
+		".text",
+		"    la $t2, notext",
+		"    lw $t0, nolabel",
+		"    j done",
+		"done:",
+		"    nop",
+	}, ass.AssembleConfig{Data: 0x00003000, Text: 0x00001000}, 0x4000)
+	diags, ok := err.(ass.Diagnostics)
+	if !ok {
+		println("No diagnostics")
+		return false
+	}
+	expected := []string{"No this symbol: nodata", "No this symbol: notext", "No this symbol: nolabel"}
+	if len(diags) != len(expected) {
+		println(diags.Error())
+		return false
+	}
+	for i, diag := range diags {
+		if diag.Message != expected[i] || diag.Line != []int{2, 4, 5}[i] {
+			println(diags.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// testUnalignedAT checks that ulw and usw reject $at, which they overwrite on the way
+func testUnalignedAT() bool {
+	result := true
+	for _, line := range []string{"ulw $t0, 0($at)", "ulw $at, 0($t0)", "usw $at, 4($t0)", "usw $t0, 4($at)"} {
+		if _, _, err := ass.Assemble([]string{".text", "    " + line}, ass.AssembleConfig{}, 0x4000); err == nil {
+			println("Accepted:", line)
+			result = false
+		}
+	}
+	return result
+}
+
+// testSplitSegment checks the lines of each section given by TrimSplitSegment
+func testSplitSegment() bool {
+	segments := ass.TrimSplitSegment(ass.NewSource("", []string{
+		"outside: .word 1",
+		".text",
+		"    nop  # comment",
+		".rodata",
+		"msg: .asciiz \"hi\"",
+		".section .mysec, \"aw\"",
+		"    .word 2",
+		".text",
+		"    jr $ra",
+	}))
+	expected := map[string][]string{
+		ass.DEFAULT_SEGMENT: {"outside: .word 1"},
+		"text":              {"nop", "jr $ra"},
+		"rodata":            {"msg: .asciiz \"hi\""},
+		"mysec":             {".word 2"},
+	}
+	result := len(segments) == len(expected)
+	for name, lines := range expected {
+		if len(segments[name]) != len(lines) {
+			result = false
+			continue
+		}
+		for i, line := range lines {
+			if segments[name][i].Text != line {
+				result = false
+			}
+		}
+	}
+	if !result {
+		fmt.Println(segments)
+	}
+	return result
+}
+
+func testRecordFiles() bool {
+	_, builded, err := ass.Assemble([]string{
+		".data",
+		"value: .word 0x11223344",
+		".text",
+		"main: lw $t0, value",
+		"    addiu $t0, $t0, 1",
+	}, ass.AssembleConfig{Data: 0x100, Text: 0x1000}, 0)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	image := memfile.Image{Base: 0, Data: builded.Bin, Entry: builded.Entry, HasEntry: true, Parts: recordParts(builded, ass.Memory{})}
+	result := true
+	for _, format := range []struct {
+		name  string
+		write func(image memfile.Image) ([]string, error)
+		read  func(content []string) (memfile.Image, error)
+		data  string // the prefix of the data records
+	}{{"Intel HEX", memfile.ToIntelHex, memfile.ReadIntelHex, ":"}, {"S-record", memfile.ToSRecord, memfile.ReadSRecord, "S1"}} {
+		lines, err := format.write(image)
+		if err != nil {
+			fmt.Println(err)
+			return false
+		}
+		// one record for each section and none for the gap between them
+		records := 0
+		for _, line := range lines {
+			if strings.HasPrefix(line, format.data) && (format.data != ":" || line[7:9] == "00") {
+				records++
+			}
+		}
+		read, err := format.read(lines)
+		if err != nil {
+			fmt.Println(err)
+			return false
+		}
+		flat := read.Flat()
+		for _, sec := range builded.Sections {
+			if int(sec.Start)+len(sec.Data) > len(flat) || string(flat[sec.Start:int(sec.Start)+len(sec.Data)]) != string(sec.Data) {
+				fmt.Printf("%s: the content of .%s is lost\n", format.name, sec.Name)
+				result = false
+			}
+		}
+		if records != 2 || read.Base != 0x100 || !read.HasEntry || read.Entry != 0x1000 {
+			fmt.Printf("%s: %d data records from 0x%x, entry 0x%x\n", format.name, records, read.Base, read.Entry)
+			result = false
+		}
+	}
+	return result
+}
+
+// testDataDirectives lays out each data directive with its alignment and reads the last byte back
+func testDataDirectives() bool {
+	regs, builded, ok := runTestProgram([]string{
+		".data",
+		"w: .word 0x11223344, -1",
+		"h: .half 0x5566",
+		"b: .byte 1, 2:3",
+		"    .align 2",
+		"a: .word 7",
+		"s: .ascii \"ab\"",
+		"z: .asciiz \"c\"",
+		"    .space 3",
+		"e: .byte 9",
+		".text",
+		"    la $t1, e",
+		"    lbu $t2, 0($t1)",
+		"    lw $t3, a",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	return ok && expectRegisters("data", regs, map[uint8]uint32{ins.GPR_T1: 0x301b, ins.GPR_T2: 9, ins.GPR_T3: 7}) &&
+		expectBytes("data", builded.Bin, 0x3000, []uint8{
+			0x44, 0x33, 0x22, 0x11, 0xff, 0xff, 0xff, 0xff, 0x66, 0x55, 1, 2, 2, 2, 0, 0,
+			7, 0, 0, 0, 'a', 'b', 'c', 0, 0, 0, 0, 9})
+}
+
+// testDataLabels jumps through a table of code labels defined after it, the last entry points into the table
+func testDataLabels() bool {
+	regs, _, ok := runTestProgram([]string{
+		".data",
+		"table: .word first, second, table+4",
+		".text",
+		"main:",
+		"    lw $t0, table+4",
+		"    jr $t0",
+		"first:",
+		"    li $s0, 1",
+		"    li $v0, 10",
+		"    syscall",
+		"second:",
+		"    li $s0, 2",
+		"    lw $s1, table+8",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	return ok && expectRegisters("table", regs, map[uint8]uint32{ins.GPR_S0: 2, ins.GPR_S1: 0x3004})
+}
+
+// testInclude includes a file found in a search directory, which includes one next to itself,
+// and a file including itself is an error at its .include line
+func testInclude() bool {
+	dir, err := os.MkdirTemp("", "mip-include")
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"defs.inc":  ".eqv VALUE, 5\n.include \"more.inc\"\n",
+		"more.inc":  ".macro setv(%reg)\n    li %reg, VALUE\n.end_macro\n",
+		"cycle.inc": "nop\n.include \"cycle.inc\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []uint8(content), 0644); err != nil {
+			println(err.Error())
+			return false
+		}
+	}
+	config := ass.AssembleConfig{IncludeDirs: []string{dir}}
+	regs, _, ok := runTestProgram([]string{
+		".include \"defs.inc\"",
+		".text",
+		"    setv($s0)",
+		"    li $v0, 10",
+		"    syscall",
+	}, config, sim.Config{})
+	if !ok || !expectRegisters("include", regs, map[uint8]uint32{ins.GPR_S0: 5}) {
+		return false
+	}
+	_, _, err = ass.Assemble([]string{".text", ".include \"cycle.inc\""}, config, 0x4000)
+	diags, _ := err.(ass.Diagnostics)
+	if len(diags) != 1 || filepath.Base(diags[0].File) != "cycle.inc" || diags[0].Line != 2 {
+		fmt.Println("Expect the cycle at cycle.inc:2, got", err)
+		return false
+	}
+	return true
+}
+
+// testHiLo builds 32-bit values with li, la and %hi/%lo, FAR has a negative %lo so %hi has to be
+// one more than its upper half, and the word is loaded with the %lo of its label as the offset
+func testHiLo() bool {
+	regs, _, ok := runTestProgram([]string{
+		".eqv FAR, 0x12348010",
+		".data",
+		"    .space 0x10",
+		"value: .word 0x12345678",
+		".text",
+		"    li $s0, 0x89abcdef",
+		"    li $s1, -2",
+		"    li $s2, 0x10000",
+		"    la $s3, FAR",
+		"    lui $t0, %hi(FAR)",
+		"    addiu $s4, $t0, %lo(FAR)",
+		"    la $s5, value",
+		"    lui $t1, %hi(value)",
+		"    lw $s6, %lo(value)($t1)",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	return ok && expectRegisters("hilo", regs, map[uint8]uint32{
+		ins.GPR_S0: 0x89abcdef, ins.GPR_S1: 0xfffffffe, ins.GPR_S2: 0x10000, ins.GPR_S3: 0x12348010,
+		ins.GPR_S4: 0x12348010, ins.GPR_S5: 0x3010, ins.GPR_S6: 0x12345678})
+}
+
+// testLabels puts several labels on one address, on the line of their statement or on lines of their own,
+// and the labels before a data item on other lines are bound to it after the alignment
+func testLabels() bool {
+	regs, builded, ok := runTestProgram([]string{
+		".data",
+		"a: b: .word 1",
+		"    .word 2",
+		"c: .byte 3",
+		"d:",
+		"e:",
+		"    .word 4",
+		".text",
+		"main: start: la $s0, b",
+		"    lw $s1, a+4",
+		"    la $s2, d",
+		"    lw $s3, e",
+		"end:",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	if !ok || !expectRegisters("labels", regs, map[uint8]uint32{ins.GPR_S0: 0x3000, ins.GPR_S1: 2, ins.GPR_S2: 0x300c, ins.GPR_S3: 4}) {
+		return false
+	}
+	addrs := make(map[string]uint32)
+	for _, symbol := range builded.Symbols {
+		addrs[symbol.Name] = symbol.Address
+	}
+	for name, addr := range map[string]uint32{"a": 0x3000, "b": 0x3000, "c": 0x3008, "d": 0x300c, "e": 0x300c, "main": 0x1000, "start": 0x1000} {
+		if val, ok := addrs[name]; !ok || val != addr {
+			fmt.Printf("labels: %s is at 0x%x, expect 0x%x\n", name, val, addr)
+			return false
+		}
+	}
+	return true
+}
+
+// testPseudo runs the pseudo-instructions and the extended operand forms of the real instructions
+func testPseudo() bool {
+	regs, _, ok := runTestProgram([]string{
+		".data",
+		"bytes: .byte 0, 0x11, 0x22, 0x33, 0x44",
+		".text",
+		"    li $t0, -7",
+		"    li $t1, 3",
+		"    li $t2, 0x80000001",
+		"    abs $s0, $t0",
+		"    rem $s1, $t0, $t1",
+		"    div $s2, $t0, 2",
+		"    mul $s3, $t1, 100",
+		"    rol $s4, $t2, 1",
+		"    ror $s5, $t2, 4",
+		"    sge $s6, $t1, 3",
+		"    sgtu $s7, $t0, $t1",
+		"    li $t3, 1",
+		"    blt $t0, 5, less",
+		"    li $t3, 2",
+		"less:",
+		"    addiu $t4, $zero, 0x12345",
+		"    sltiu $t5, $t1, 0x10000",
+		"    subi $t6, $t1, 5",
+		"    la $t8, bytes",
+		"    ulw $t7, 1($t8)",
+		"    seq $t9, $t1, 3",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	return ok && expectRegisters("pseudo", regs, map[uint8]uint32{
+		ins.GPR_S0: 7, ins.GPR_S1: 0xffffffff, ins.GPR_S2: 0xfffffffd, ins.GPR_S3: 300, ins.GPR_S4: 3, ins.GPR_S5: 0x18000000,
+		ins.GPR_S6: 1, ins.GPR_S7: 1, ins.GPR_T3: 1, ins.GPR_T4: 0x12345, ins.GPR_T5: 1, ins.GPR_T6: 0xfffffffe,
+		ins.GPR_T7: 0x44332211, ins.GPR_T9: 1})
+}
+
+// testListing checks the listing lines of an expanded pseudo-instruction, a load of a label and data longer than a line
+func testListing() bool {
+	_, builded, err := ass.AssembleSource(ass.NewSource("l.asm", []string{
+		".data",
+		"v: .word 1, 2",
+		"s: .asciiz \"hello world, long\"",
+		".text",
+		"main: li $t0, 0x12345678",
+		" lw $t1, v",
+	}), ass.AssembleConfig{Data: 0x3000, Text: 0x1000}, 0)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	expected := []string{
+		"Address   Code                     Expansion                     Source",
+		"00001000  3c011234                 lui     $1, 0x1234            l.asm:5  main: li $t0, 0x12345678",
+		"00001004  34285678                 ori     $8, $1, 0x5678",
+		"00001008  8c093000                 lw      $9, 0x3000($0)        l.asm:6  lw $t1, v",
+		"00003000  01 00 00 00 02 00 00 00                                l.asm:2  v: .word 1, 2",
+		"00003008  68 65 6c 6c 6f 20 77 6f                                l.asm:3  s: .asciiz \"hello world, long\"",
+		"00003010  72 6c 64 2c 20 6c 6f 6e",
+		"00003018  67 00",
+		"",
+		"Symbols:",
+		"00001000  main",
+		"00003000  v",
+		"00003008  s",
+	}
+	lines := toListing(builded)
+	if len(lines) != len(expected) {
+		fmt.Println(strings.Join(lines, "\n"))
+		return false
+	}
+	for i, line := range lines {
+		if line != expected[i] {
+			fmt.Printf("listing: line %d is %q, expect %q\n", i+1, line, expected[i])
+			return false
+		}
+	}
+	return true
+}
+
+// testSymbolMap checks the sizes, the segments and the kinds in the map, and reads the labels of the map back
+func testSymbolMap() bool {
+	_, builded, err := ass.Assemble([]string{
+		".eqv SIZE, 8",
+		".data",
+		"msg: .asciiz \"hi\"",
+		"buf: .space SIZE",
+		".text",
+		"main: nop",
+		"loop: j loop",
+	}, ass.AssembleConfig{Data: 0x200, Text: 0}, 0)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	expected := []string{
+		"# Address   Size      Segment  Kind      Name",
+		"00000000    00000004  text     label     main",
+		"00000004    00000008  text     label     loop",
+		"00000008    00000000  abs      constant  SIZE",
+		"00000200    00000003  data     label     msg",
+		"00000203    00000008  data     label     buf",
+	}
+	lines := toMap(builded.Symbols)
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		fmt.Println(strings.Join(lines, "\n"))
+		return false
+	}
+	labels, err := fromMap(lines)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if fmt.Sprint(labels) != fmt.Sprint(map[uint32][]string{0: {"main"}, 4: {"loop"}, 0x200: {"msg"}, 0x203: {"buf"}}) {
+		fmt.Println("map: read back", labels)
+		return false
+	}
+	return true
+}
+
+// testELF writes the program as an ELF executable, loads it back as sim does, and runs it from its entry
+func testELF() bool {
+	_, builded, err := ass.Assemble([]string{
+		".data",
+		"value: .word 0x1234",
+		".bss",
+		"buf: .space 8",
+		".text",
+		"    li $s0, 1",
+		"main:",
+		"    lw $s1, value",
+		"    la $s2, buf",
+		"    lw $s3, 4($s2)",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{Data: 0x3000, Text: 0x1000}, 0)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	file, err := os.CreateTemp("", "mip-*.elf")
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	if err = writeAllBytes(file.Name(), builded.ToELF()); err != nil {
+		println(err.Error())
+		return false
+	}
+	if _, _, err = readELF(file.Name(), true, nil); err == nil {
+		println("The little-endian ELF is read as big-endian")
+		return false
+	}
+	image, entry, err := readELF(file.Name(), false, nil)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if entry != 0x1004 {
+		fmt.Printf("elf: the entry is 0x%08x, expect main\n", entry)
+		return false
+	}
+	if !sim.Initialize(image, sim.Config{Quiet: true}, breakHandler, nil) || !sim.Execute(entry, false) {
+		println("The ELF doesn't run to the end")
+		return false
+	}
+	return expectRegisters("elf", simRegisters(), map[uint8]uint32{ins.GPR_S0: 0, ins.GPR_S1: 0x1234, ins.GPR_S2: 0x3004, ins.GPR_S3: 0})
+}
+
+// testLink assembles two objects, reads them back from their ELF files and links them, the table of
+// lib is after the data of main, so the HI16/LO16 pairs and the word of main carry addends to relocate
+func testLink() bool {
+	sources := map[string][]string{
+		"main.o": {
+			".globl main",
+			".extern add3, table",
+			".data",
+			"first: .word 5",
+			"ptr: .word table+8",
+			".text",
+			"main:",
+			"    la $t0, table",
+			"    lw $s0, 4($t0)",
+			"    lw $s1, table+8",
+			"    lw $t1, ptr",
+			"    lw $s3, 0($t1)",
+			"    li $a0, 4",
+			"    jal add3",
+			"    li $v0, 10",
+			"    syscall",
+		},
+		"lib.o": {
+			".globl add3, table",
+			".data",
+			"    .space 0x10",
+			"table: .word 1, 2, 3",
+			".text",
+			"add3:",
+			"    addiu $s2, $a0, 3",
+			"    jr $ra",
+		},
+	}
+	objects := make([]ass.Object, 0, 2)
+	for _, name := range []string{"main.o", "lib.o"} {
+		_, builded, err := ass.Assemble(sources[name], ass.AssembleConfig{Relocatable: true}, 0)
+		if err != nil {
+			println(err.Error())
+			return false
+		}
+		obj, err := ass.ReadObject(name, builded.Object(name).ToELF())
+		if err != nil {
+			println(err.Error())
+			return false
+		}
+		objects = append(objects, obj)
+	}
+	_, linked, err := ass.Link(objects, ass.AssembleConfig{Data: 0x3000, Text: 0x1000}, 0x4000)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	if !sim.Initialize(linked.Bin, sim.Config{Quiet: true}, breakHandler, nil) || !sim.Execute(linked.Entry, false) {
+		println("The linked program doesn't run to the end")
+		return false
+	}
+	return expectRegisters("link", simRegisters(), map[uint8]uint32{ins.GPR_T0: 0x3018, ins.GPR_S0: 2, ins.GPR_S1: 3, ins.GPR_S2: 7, ins.GPR_S3: 3})
+}
+
+// testSections places the data sections in their order with .bss last, and calls the code in .ktext
+// at its given base and in a custom code section following it
+func testSections() bool {
+	regs, _, ok := runTestProgram([]string{
+		".bss",
+		"b: .space 4",
+		".data",
+		"a: .word 1",
+		".section .mydata, \"aw\"",
+		"m: .word 4",
+		".kdata",
+		"k: .byte 3",
+		".rodata",
+		"r: .word 2",
+		".text",
+		"main:",
+		"    la $s0, r",
+		"    la $s1, k",
+		"    la $s2, m",
+		"    la $s3, b",
+		"    jal handler",
+		"    jal vec",
+		"    li $v0, 10",
+		"    syscall",
+		".section .vectors, ax",
+		"vec:",
+		"    la $s5, vec",
+		"    jr $ra",
+		".ktext",
+		"handler:",
+		"    lw $s4, m",
+		"    jr $ra",
+	}, ass.AssembleConfig{Sections: map[string]uint32{"ktext": 0x2000}}, sim.Config{})
+	return ok && expectRegisters("sections", regs, map[uint8]uint32{
+		ins.GPR_S0: 0x3004, ins.GPR_S1: 0x3008, ins.GPR_S2: 0x300c, ins.GPR_S3: 0x3010, ins.GPR_S4: 4, ins.GPR_S5: 0x2008})
+}
+
+// testLayout checks the errors of overlapping sections and of a section out of the image, an empty section
+// at the same address as another one, and the free space given by the usage
+func testLayout() bool {
+	config := ass.AssembleConfig{Data: 0x3000, Text: 0x1000, Sections: map[string]uint32{"rodata": 0x3004, "kdata": 0x3ff8}}
+	cases := []struct {
+		program []string
+		message string
+	}{
+		{[]string{".data", ".word 1, 2", ".rodata", ".word 3"}, "Section .rodata [0x00003004, 0x00003008) overlaps .data [0x00003000, 0x00003008)"},
+		{[]string{".kdata", ".space 0x10"}, "Section .kdata [0x00003ff8, 0x00004008) is out of the image [0x00000000, 0x00004000)"},
+	}
+	for _, item := range cases {
+		_, _, err := ass.Assemble(item.program, config, 0x4000)
+		diags, _ := err.(ass.Diagnostics)
+		if len(diags) != 1 || diags[0].Message != item.message {
+			fmt.Printf("layout: expect %q, got %v\n", item.message, err)
+			return false
+		}
+	}
+	_, builded, err := ass.Assemble([]string{".data", ".word 1, 2", ".rodata", ".text", "nop"}, config, 0x4000)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	expected := []ass.SectionUsage{
+		{Name: "text", Start: 0x1000, Used: 4, Free: 0x1ffc},
+		{Name: "data", Start: 0x3000, Used: 8, Free: 0},
+		{Name: "rodata", Start: 0x3004, Used: 0, Free: 0xffc},
+	}
+	if fmt.Sprint(builded.Usage()) != fmt.Sprint(expected) {
+		fmt.Println("layout: the usage is", builded.Usage())
+		return false
+	}
+	return true
+}
+
+// testMemoryFiles writes 6 bytes as 32-bit words padded to a depth of 4 in each format, and as 16-bit
+// big-endian words for $readmemb, whose words give back the bytes
+func testMemoryFiles() bool {
+	image := []uint8{0x78, 0x56, 0x34, 0x12, 0xef, 0xbe}
+	opt := memfile.NewOptions(32)
+	opt.Depth = 4
+	mif, _ := memfile.ToMIF(image, opt)
+	coe, _ := memfile.ToCOE(image, opt)
+	memh, _ := memfile.ToReadmem(image, opt, 16)
+	half := memfile.Options{Width: 16, BigEndian: true, AddressRadix: 10}
+	memb, _ := memfile.ToReadmem(image, half, 2)
+	expected := map[string][][]string{
+		"MIF": {mif, {"WIDTH=32;", "DEPTH=4;", "ADDRESS_RADIX=HEX;", "DATA_RADIX=HEX;", "CONTENT BEGIN",
+			"    0 : 12345678;", "    1 : 0000BEEF;", "    2 : 00000000;", "    3 : 00000000;", "END;"}},
+		"COE":       {coe, {"memory_initialization_radix=16;", "memory_initialization_vector=", "12345678,", "0000BEEF,", "00000000,", "00000000;"}},
+		"$readmemh": {memh, {"12345678 // 0", "0000BEEF // 1", "00000000 // 2", "00000000 // 3"}},
+		"$readmemb": {memb, {"0111100001010110 // 0", "0011010000010010 // 1", "1110111110111110 // 2"}},
+	}
+	result := true
+	for name, lines := range expected {
+		if strings.Join(lines[0], "\n") != strings.Join(lines[1], "\n") {
+			fmt.Printf("%s:\n%s\n", name, strings.Join(lines[0], "\n"))
+			result = false
+		}
+	}
+	words, err := memfile.Words(image, half)
+	if err == nil {
+		var bytes []uint8
+		if bytes, err = memfile.Bytes(words, half); err == nil && string(bytes) != string(image) {
+			err = fmt.Errorf("the bytes are % x", bytes)
+		}
+	}
+	if err != nil {
+		fmt.Println("memory files:", err)
+		result = false
+	}
+	if _, err = memfile.ToMIF(image, memfile.Options{Width: 32, Depth: 1, AddressRadix: 16}); err == nil {
+		println("The image deeper than the memory is accepted")
+		result = false
+	}
+	return result
+}
+
+// testHarvard places the code and the data both at 0 in their own memories, and runs the program
+// in the split memories of the simulator, where a store to 0 doesn't overwrite the code
+func testHarvard() bool {
+	_, builded, err := ass.Assemble([]string{
+		".data",
+		"v: .word 0x55",
+		"w: .word 0",
+		".text",
+		"main:",
+		"    lw $s0, v",
+		"    la $s1, w",
+		"    sw $s1, 0($zero)",
+		"    lw $s2, 0($zero)",
+		"    li $s3, 1",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{Harvard: true}, 0)
+	if err != nil {
+		println(err.Error())
+		return false
+	}
+	images := make(map[string]ass.Memory)
+	for _, mem := range builded.Memories {
+		images[mem.Name] = mem
+	}
+	code, data := images["text"], images["data"]
+	if len(builded.Memories) != 2 || len(code.Bin) != 32 || len(data.Bin) != 8 || code.Full.Start != 0 || data.Full.Start != 0 {
+		fmt.Printf("harvard: the memories are %v\n", builded.Memories)
+		return false
+	}
+	if !sim.InitializeSplit(code.Bin, code.Full.Start, data.Bin, data.Full.Start, sim.Config{Quiet: true}, breakHandler, nil) || !sim.Execute(builded.Entry, false) {
+		println("The Harvard program doesn't run to the end")
+		return false
+	}
+	return expectRegisters("harvard", simRegisters(), map[uint8]uint32{ins.GPR_S0: 0x55, ins.GPR_S1: 4, ins.GPR_S2: 4, ins.GPR_S3: 1})
+}
+
+// testLogisim writes the words with a run of zeros in both versions, and reads them back with a plain file
+func testLogisim() bool {
+	opt := memfile.NewOptions(32)
+	words := []uint32{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xdeadbeef, 2}
+	image, _ := memfile.Bytes(words, opt)
+	raw, _ := memfile.ToLogisim(image, opt, 2)
+	addressed, _ := memfile.ToLogisim(image, opt, 3)
+	plain := []string{memfile.LOGISIM_PLAIN, "# a comment", "1 0 0 0 0 0 0 0", "0 0 deadbeef 2"}
+	result := true
+	for _, item := range []struct {
+		name     string
+		lines    []string
+		expected []string
+	}{
+		{"raw", raw, []string{memfile.LOGISIM_RAW, "1 9*0 deadbeef 2"}},
+		{"addressed", addressed, []string{memfile.LOGISIM_ADDRESSED, "0: 1 9*0 deadbeef 2"}},
+		{"plain", plain, plain},
+	} {
+		if strings.Join(item.lines, "\n") != strings.Join(item.expected, "\n") {
+			fmt.Printf("logisim %s:\n%s\n", item.name, strings.Join(item.lines, "\n"))
+			result = false
+		}
+		read, err := memfile.FromLogisim(item.lines, opt)
+		if err != nil || string(read) != string(image) {
+			fmt.Printf("logisim %s: read back % x, %v\n", item.name, read, err)
+			result = false
+		}
+	}
+	return result
+}
+
+// testReorder fills the slot of a loop branch with the independent instruction before it, puts a nop
+// after a branch reading the register written before it, and keeps the slot written by hand under noreorder
+func testReorder() bool {
+	regs, builded, ok := runTestProgram([]string{
+		".text",
+		"main:",
+		"    li $t0, 3",
+		"loop:",
+		"    addiu $t0, $t0, -1",
+		"    addiu $s0, $s0, 2",
+		"    bnez $t0, loop",
+		"    li $t1, 5",
+		"    addiu $t1, $t1, 1",
+		"    beq $t1, $zero, main",
+		"    .set noreorder",
+		"    b end",
+		"    addiu $s2, $zero, 7",
+		"    .set reorder",
+		"    addiu $s3, $zero, 9",
+		"end:",
+		"    li $v0, 10",
+		"    syscall",
+	}, ass.AssembleConfig{}, sim.Config{})
+	if !ok || !expectRegisters("reorder", regs, map[uint8]uint32{ins.GPR_T0: 0, ins.GPR_S0: 6, ins.GPR_T1: 6, ins.GPR_S2: 7, ins.GPR_S3: 0}) {
+		return false
+	}
+	if builded.Text.End != 0x1034 {
+		fmt.Printf("reorder: the text ends at 0x%x, expect 13 instructions\n", builded.Text.End)
+		return false
+	}
+	return expectBytes("reorder", builded.Bin, 0x100c, []uint8{0x02, 0x00, 0x10, 0x26}) &&
+		expectBytes("reorder", builded.Bin, 0x101c, []uint8{0, 0, 0, 0}) && expectBytes("reorder", builded.Bin, 0x1024, []uint8{0x07, 0x00, 0x12, 0x24})
+}
+
+// the load before the first b isn't moved into its slot by -loaddelay, where b's target would miss it,
+// the slot of the second b sets $s0, and the register of a load is read right after it by $s1 and one later by $s2
+var delayProgram = []string{
+	".data",
+	"v: .word 0x77",
+	".text",
+	"main:",
+	"    lw $t1, v",
+	"    b second",
+	"second:",
+	"    addu $s3, $t1, $zero",
+	"    .set noreorder",
+	"    b first",
+	"    li $s0, 1",
+	"first:",
+	"    lw $t0, v",
+	"    addu $s1, $t0, $zero",
+	"    addu $s2, $t0, $zero",
+	"    li $v0, 10",
+	"    syscall",
+}
+
+// testDelaySlots runs the program with the branch delay slots, without them like MARS, and with the load delay
+func testDelaySlots() bool {
+	result := true
+	for _, item := range []struct {
+		name     string
+		config   sim.Config
+		expected map[uint8]uint32
+	}{
+		{"delay", sim.Config{}, map[uint8]uint32{ins.GPR_S0: 1, ins.GPR_S1: 0x77, ins.GPR_S2: 0x77, ins.GPR_S3: 0x77}},
+		{"nodelay", sim.Config{NoDelaySlot: true}, map[uint8]uint32{ins.GPR_S0: 0, ins.GPR_S1: 0x77, ins.GPR_S2: 0x77, ins.GPR_S3: 0x77}},
+		{"loaddelay", sim.Config{LoadDelay: true}, map[uint8]uint32{ins.GPR_S0: 1, ins.GPR_S1: 0, ins.GPR_S2: 0x77, ins.GPR_S3: 0x77}},
+	} {
+		// as sim does with -asm, a slot filled by the assembler would be skipped by a taken branch without delay slots
+		config := ass.AssembleConfig{LoadDelay: item.config.LoadDelay, NoReorder: item.config.NoDelaySlot}
+		regs, _, ok := runTestProgram(delayProgram, config, item.config)
+		if !ok || !expectRegisters(item.name, regs, item.expected) {
+			result = false
+		}
+	}
+	return result
+}
+
+// selfTest is a check run by the test verb
+type selfTest struct {
+	name string
+	run  func() bool
+}
+
+var selfTests = []selfTest{
+	{"endianness", testEndianness},
+	{"diagnostics", testDiagnostics},
+	{"macro-labels", testMacroLabels},
+	{"ranges", testRanges},
+	{"set-options", testSetOptions},
+	{"split-segment", testSplitSegment},
+	{"unaligned-at", testUnalignedAT},
+	{"relax-link", testRelaxLink},
+	{"relax-sections", testRelaxSections},
+	{"encoding", func() bool { return testToAndParse(createTestInstructions()) }},
+	{"encoding-fixes", testFixedEncodings},
+	{"unaligned", testUnaligned},
+	{"accumulator", testAccumulator},
+	{"traps", testTraps},
+	{"branch-likely", testBranchLikely},
+	{"release2", testRelease2},
+	{"record-files", testRecordFiles},
+	{"data-directives", testDataDirectives},
+	{"data-labels", testDataLabels},
+	{"include", testInclude},
+	{"hi-lo", testHiLo},
+	{"labels", testLabels},
+	{"pseudo", testPseudo},
+	{"listing", testListing},
+	{"symbol-map", testSymbolMap},
+	{"elf", testELF},
+	{"link", testLink},
+	{"sections", testSections},
+	{"layout", testLayout},
+	{"memory-files", testMemoryFiles},
+	{"harvard", testHarvard},
+	{"logisim", testLogisim},
+	{"reorder", testReorder},
+	{"delay-slots", testDelaySlots},
+}
+
+// run the check, a panic fails it with its message in one line
+func (this selfTest) check() (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("%s: panic: %v\n", this.name, err)
+			ok = false
+		}
+	}()
+	return this.run()
+}
+
+// runSelfTests runs the checks in order, the result is false if any of them fails
+func runSelfTests() bool {
+	failed := 0
+	for _, test := range selfTests {
+		if test.check() {
+			fmt.Printf("%s: ok\n", test.name)
+		} else {
+			fmt.Printf("%s: FAILED\n", test.name)
+			failed++
+		}
+	}
+	fmt.Printf("%d of %d tests failed\n", failed, len(selfTests))
+	return failed == 0
+}